@@ -0,0 +1,50 @@
+package concurrency
+
+import (
+	"time"
+)
+
+// Backoff returns the delay before the given attempt, starting from 1, given
+// the previous delay.
+type Backoff func(attempt int, previous time.Duration) time.Duration
+
+// ExponentialBackoff returns a [Backoff] that starts at base and doubles on
+// each attempt, up to maxDelay.
+func ExponentialBackoff(base, maxDelay time.Duration) Backoff {
+	return func(attempt int, previous time.Duration) time.Duration {
+		delay := base
+		for i := 1; i < attempt && delay < maxDelay; i++ {
+			delay *= 2
+		}
+		return min(delay, maxDelay)
+	}
+}
+
+// ConstantBackoff returns a [Backoff] that always waits delay.
+func ConstantBackoff(delay time.Duration) Backoff {
+	return func(attempt int, previous time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// DecorrelatedJitterBackoff returns a [Backoff] that picks a random delay
+// between base and three times the previous delay, up to maxDelay, as with
+// [DecorrelatedJitter].
+func DecorrelatedJitterBackoff(base, maxDelay time.Duration) Backoff {
+	return func(attempt int, previous time.Duration) time.Duration {
+		// The first attempt starts from base, as with DecorrelatedJitter.
+		return decorrelated(base, maxDelay, max(previous, base))
+	}
+}
+
+// sleep for delay or until done is closed, returning false in the latter case.
+func sleep(done <-chan struct{}, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,106 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrSendTimeout is returned when a value cannot be sent to a full channel
+// within the timeout of [ErrorAfter].
+var ErrSendTimeout = errors.New("concurrency: timed out sending to full channel")
+
+type backpressureKind int
+
+const (
+	backpressureBlock backpressureKind = iota
+	backpressureDropNewest
+	backpressureDropOldest
+	backpressureTimeout
+)
+
+// Backpressure is the policy used when sending a value to a full channel.
+//
+// The zero value is [Block].
+type Backpressure struct {
+	kind    backpressureKind
+	timeout time.Duration
+}
+
+// Block until the value can be sent, or the tree is cancelled.
+func Block() Backpressure { return Backpressure{} }
+
+// DropNewest discards the value being sent.
+func DropNewest() Backpressure { return Backpressure{kind: backpressureDropNewest} }
+
+// DropOldest discards the oldest value in the channel to make room for the
+// value being sent.
+//
+// This requires the channel to be owned by the package, eg. by [NewChannel].
+// Otherwise it is equivalent to [DropNewest].
+func DropOldest() Backpressure { return Backpressure{kind: backpressureDropOldest} }
+
+// ErrorAfter fails with [ErrSendTimeout] if the value cannot be sent within
+// timeout.
+func ErrorAfter(timeout time.Duration) Backpressure {
+	return Backpressure{kind: backpressureTimeout, timeout: timeout}
+}
+
+// sendWith sends value to dest according to policy, calling onDrop with any
+// discarded value and returning whether value was sent. recv is dest, if it
+// can be received from.
+func sendWith[T any](ctx context.Context, dest chan<- T, recv chan T, value T, policy Backpressure, onDrop func(T)) (bool, error) {
+	select {
+	case dest <- value:
+		return true, nil
+	default:
+	}
+	dropped := func(value T) {
+		if onDrop != nil {
+			onDrop(value)
+		}
+	}
+	switch policy.kind {
+	case backpressureDropOldest:
+		if recv != nil {
+			for {
+				select {
+				case oldest := <-recv:
+					dropped(oldest)
+				default:
+				}
+				select {
+				case dest <- value:
+					return true, nil
+				default:
+				}
+			}
+		}
+		dropped(value)
+		return false, nil
+
+	case backpressureDropNewest:
+		dropped(value)
+		return false, nil
+
+	case backpressureTimeout:
+		timer := time.NewTimer(policy.timeout)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-timer.C:
+			return false, ErrSendTimeout
+		case dest <- value:
+			return true, nil
+		}
+
+	default:
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case dest <- value:
+			return true, nil
+		}
+	}
+}
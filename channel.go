@@ -2,43 +2,521 @@ package concurrency
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"time"
 )
 
 // Channel utilises a tree to produce values and send them to a channel.
 type Channel[T any] struct {
-	tree *Tree
-	dest chan<- T
+	tree  *Tree
+	dest  chan<- T
+	close *sync.Once
+	owned bool       // Close dest on Wait.
+	order *sequencer // Non-nil if output is ordered.
+	recv  chan T     // dest, if owned.
+
+	backpressure Backpressure
+	onDrop       func(T)
+	unseen       func(T) bool // Non-nil if output is deduplicated.
+	errs         chan<- error // Non-nil if errors are sent rather than cancelling the tree.
+	emitRate     *rateLimiter
+}
+
+// channelOptions are the options of a [Channel], set on its tree.
+type channelOptions struct {
+	ordered      bool
+	backpressure Backpressure
+	emitRate     *rateLimiter
+	onDrop       any // func(T)
+	dedup        any // func() func(T) bool
+}
+
+// Ordered sends values to the destination channel of a [Channel] in the order
+// in which Go was called, or values were received by a pipeline stage, rather
+// than in the order they are produced.
+//
+// Implies [WithFIFO].
+func Ordered() Option {
+	return func(o *Tree) {
+		o.channel.ordered = true
+		WithFIFO()(o)
+	}
+}
+
+// WithBackpressure sets the policy used by a [Channel] when the destination
+// channel is full.
+//
+// Use [OnDrop] to be notified of values discarded by the policy.
+func WithBackpressure(policy Backpressure) Option {
+	return func(o *Tree) {
+		o.channel.backpressure = policy
+	}
+}
+
+// OnDrop calls fn with each value discarded by the [WithBackpressure] policy of
+// a [Channel].
+//
+// T must be the value type of the Channel.
+func OnDrop[T any](fn func(T)) Option {
+	return func(o *Tree) {
+		o.channel.onDrop = fn
+	}
+}
+
+// WithEmitRate limits the rate at which a [Channel] sends values to the
+// destination channel to rps per second, with bursts of up to burst values,
+// independently of how quickly they are produced.
+func WithEmitRate(rps float64, burst int) Option {
+	return func(o *Tree) {
+		o.channel.emitRate = newRateLimiter(rps, burst)
+	}
+}
+
+// Deduplicate suppresses values sent by a [Channel] that are equal to a value
+// it has already sent.
+//
+// T must be the value type of the Channel. Every distinct value is retained
+// for the lifetime of the Channel, so memory use grows with the number of
+// distinct values sent.
+func Deduplicate[T comparable]() Option {
+	return DeduplicateBy(func(value T) T { return value })
+}
+
+// DeduplicateBy suppresses values sent by a [Channel] whose key is equal to
+// that of a value it has already sent.
+//
+// As with [Deduplicate], every distinct key is retained for the lifetime of the
+// Channel.
+func DeduplicateBy[T any, K comparable](key func(T) K) Option {
+	return func(o *Tree) {
+		o.channel.dedup = func() func(T) bool {
+			var lock sync.Mutex
+			keys := map[K]struct{}{}
+			return func(value T) bool {
+				k := key(value)
+				lock.Lock()
+				defer lock.Unlock()
+				if _, ok := keys[k]; ok {
+					return false
+				}
+				keys[k] = struct{}{}
+				return true
+			}
+		}
+	}
+}
+
+func newChannel[T any](ctx context.Context, dest chan<- T, recv chan T, options []Option) (*Channel[T], context.Context) {
+	tree, ctx := New(ctx, options...)
+	config := tree.channel
+	v := &Channel[T]{tree: tree, dest: dest, close: &sync.Once{}, owned: recv != nil, recv: recv, backpressure: config.backpressure, emitRate: config.emitRate}
+	if config.ordered {
+		v.order = newSequencer()
+	}
+	if config.onDrop != nil {
+		onDrop, ok := config.onDrop.(func(T))
+		if !ok {
+			panic(fmt.Sprintf("concurrency: OnDrop for %T used with a Channel of %T", config.onDrop, *new(T)))
+		}
+		v.onDrop = onDrop
+	}
+	if config.dedup != nil {
+		dedup, ok := config.dedup.(func() func(T) bool)
+		if !ok {
+			panic(fmt.Sprintf("concurrency: DeduplicateBy for %T used with a Channel of %T", config.dedup, *new(T)))
+		}
+		v.unseen = dedup()
+	}
+	return v, ctx
 }
 
 // ToChannel creates a new [Channel] instance.
 func ToChannel[T any](ctx context.Context, dest chan<- T, options ...Option) (*Channel[T], context.Context) {
-	tree, ctx := New(ctx, options...)
-	return &Channel[T]{tree: tree, dest: dest}, ctx
+	return newChannel(ctx, dest, nil, options)
+}
+
+// ToChannels is like [ToChannel], but errors returned by functions are sent to
+// errs rather than cancelling the tree, so that consumers can handle failures
+// of individual values.
+//
+// Panics still cancel the tree.
+func ToChannels[T any](ctx context.Context, values chan<- T, errs chan<- error, options ...Option) (*Channel[T], context.Context) {
+	v, ctx := newChannel(ctx, values, nil, options)
+	v.errs = errs
+	return v, ctx
+}
+
+// NewChannel creates a new [Channel] that sends to a channel with the given
+// buffer size, which is returned.
+//
+// The channel is closed when Wait returns, so receivers can range over it, but
+// Wait must be called for this to happen.
+func NewChannel[T any](ctx context.Context, buffer int, options ...Option) (*Channel[T], <-chan T, context.Context) {
+	dest := make(chan T, buffer)
+	v, ctx := newChannel(ctx, dest, dest, options)
+	return v, dest, ctx
 }
 
 func (v *Channel[T]) Go(fn func(context.Context) (T, error)) {
+	emit, done := v.emitter()
 	v.tree.Go(func(ctx context.Context) error {
+		defer done()
 		value, err := fn(ctx)
 		if err != nil {
+			return v.fail(ctx, err)
+		}
+		return emit(ctx, value)
+	})
+}
+
+// GoMaybe is like Go, but nothing is sent if fn returns false.
+func (v *Channel[T]) GoMaybe(fn func(context.Context) (T, bool, error)) {
+	v.GoMany(func(ctx context.Context, emit func(T) error) error {
+		value, ok, err := fn(ctx)
+		if err != nil || !ok {
 			return err
 		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
+		return emit(value)
+	})
+}
+
+// GoMany is like Go, but fn may send any number of values by calling emit.
+//
+// emit blocks until the value is sent, returning an error if the tree is
+// cancelled first.
+func (v *Channel[T]) GoMany(fn func(ctx context.Context, emit func(T) error) error) {
+	emit, done := v.emitter()
+	v.tree.Go(func(ctx context.Context) error {
+		defer done()
+		return v.fail(ctx, fn(ctx, func(value T) error { return emit(ctx, value) }))
+	})
+}
+
+// fail sends err to the error channel if there is one, otherwise returns it.
+func (v *Channel[T]) fail(ctx context.Context, err error) error {
+	if err == nil || v.errs == nil || ctx.Err() != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case v.errs <- err:
+		return nil
+	}
+}
+
+// Consume sends values received from src to the destination channel until src
+// is closed, as with GoMany.
+func (v *Channel[T]) Consume(src <-chan T) {
+	v.GoMany(func(ctx context.Context, emit func(T) error) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
 
-		case v.dest <- value:
-			return nil
+			case value, ok := <-src:
+				if !ok {
+					return nil
+				}
+				if err := emit(value); err != nil {
+					return err
+				}
+			}
 		}
 	})
 }
 
-func (v *Channel[T]) Sub(fn func(context.Context, *Channel[T]) error) {
-	v.tree.Sub(func(ctx context.Context, sg *Tree) error {
-		sub := &Channel[T]{tree: sg, dest: v.dest}
-		return fn(ctx, sub)
+// emitter returns a function that sends values to dest, and a function that
+// must be called once no more values will be sent.
+//
+// If the Channel is ordered, values are sent after those of all earlier calls
+// to emitter are done.
+func (v *Channel[T]) emitter() (emit func(context.Context, T) error, done func()) {
+	if v.order == nil {
+		return v.send, func() {}
+	}
+	ticket := v.order.Ticket()
+	turn := false
+	emit = func(ctx context.Context, value T) error {
+		if !turn {
+			if err := v.order.Wait(ctx, ticket); err != nil {
+				return err
+			}
+			turn = true
+		}
+		return v.send(ctx, value)
+	}
+	return emit, func() { v.order.Done(ticket) }
+}
+
+func (v *Channel[T]) send(ctx context.Context, value T) error {
+	if v.unseen != nil && !v.unseen(value) {
+		return nil
+	}
+	if v.emitRate != nil {
+		if err := v.emitRate.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	metrics, ok := v.tree.metrics.(ChannelMetrics)
+	if !ok {
+		_, err := sendWith(ctx, v.dest, v.recv, value, v.backpressure, v.onDrop)
+		return err
+	}
+	start := time.Now()
+	sent, err := sendWith(ctx, v.dest, v.recv, value, v.backpressure, func(value T) {
+		metrics.ValueDropped()
+		if v.onDrop != nil {
+			v.onDrop(value)
+		}
 	})
+	if sent {
+		metrics.ValueSent(time.Since(start))
+	}
+	return err
+}
+
+// Sub calls fn with a Channel for a new sub-tree, as with [Tree.Sub].
+func (v *Channel[T]) Sub(fn func(context.Context, *Channel[T]) error, options ...Option) {
+	v.tree.Sub(func(ctx context.Context, sg *Tree) error {
+		sub := *v
+		sub.tree, sub.owned = sg, false
+		return fn(ctx, &sub)
+	}, options...)
 }
 
 func (v *Channel[T]) Wait() error {
+	if v.owned {
+		return v.CloseWait()
+	}
 	return v.tree.Wait()
 }
+
+// CloseWait is like Wait, but closes the destination channel once all
+// functions have completed, so that receivers can range over it.
+//
+// The channel is closed at most once. CloseWait should not be called on a
+// sub-tree Channel while its parent is still running.
+func (v *Channel[T]) CloseWait() error {
+	err := v.tree.Wait()
+	v.close.Do(func() { close(v.dest) })
+	return err
+}
+
+// MapChannel starts a pipeline stage that receives values from in with workers
+// concurrent goroutines, and sends the result of fn for each to the returned
+// channel.
+//
+// The returned channel is closed when Wait returns. Output order is not
+// preserved.
+func MapChannel[T, U any](ctx context.Context, in <-chan T, workers int, fn func(context.Context, T) (U, error), options ...Option) (*Channel[U], <-chan U) {
+	out, dest, _ := NewChannel[U](ctx, 0, options...)
+	consume(out, in, workers, func(ctx context.Context, value T, emit func(context.Context, U) error) error {
+		result, err := fn(ctx, value)
+		if err != nil {
+			return err
+		}
+		return emit(ctx, result)
+	})
+	return out, dest
+}
+
+// FilterChannel starts a pipeline stage that receives values from in with
+// workers concurrent goroutines, and sends those for which predicate returns
+// true to the returned channel.
+//
+// The returned channel is closed when Wait returns. Output order is not
+// preserved.
+func FilterChannel[T any](ctx context.Context, in <-chan T, workers int, predicate func(context.Context, T) (bool, error), options ...Option) (*Channel[T], <-chan T) {
+	out, dest, _ := NewChannel[T](ctx, 0, options...)
+	consume(out, in, workers, func(ctx context.Context, value T, emit func(context.Context, T) error) error {
+		ok, err := predicate(ctx, value)
+		if err != nil || !ok {
+			return err
+		}
+		return emit(ctx, value)
+	})
+	return out, dest
+}
+
+// BatchChannel starts a pipeline stage that groups values received from in
+// into slices of up to size values, sending them to the returned channel.
+//
+// A partial batch is sent once maxDelay has elapsed since its first value was
+// received, when in is closed, or on a best-effort basis if the tree is
+// cancelled. The returned channel is closed when Wait returns.
+func BatchChannel[T any](ctx context.Context, in <-chan T, size int, maxDelay time.Duration, options ...Option) (*Channel[[]T], <-chan []T) {
+	// Buffered so that a partial batch can be delivered on cancellation.
+	out, dest, _ := NewChannel[[]T](ctx, 1, options...)
+	out.tree.Go(func(ctx context.Context) error {
+		var batch []T
+		timer := time.NewTimer(maxDelay)
+		stop := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		stop()
+		defer stop()
+		flush := func() error {
+			stop()
+			if len(batch) == 0 {
+				return nil
+			}
+			err := out.send(ctx, batch)
+			batch = nil
+			return err
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				if len(batch) > 0 {
+					select {
+					case out.dest <- batch:
+					default:
+					}
+				}
+				return ctx.Err()
+
+			case <-timer.C:
+				if err := flush(); err != nil {
+					return err
+				}
+
+			case value, ok := <-in:
+				if !ok {
+					return flush()
+				}
+				batch = append(batch, value)
+				if len(batch) == 1 {
+					timer.Reset(maxDelay)
+				}
+				if len(batch) >= size {
+					if err := flush(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	})
+	return out, dest
+}
+
+// consume values from in with workers goroutines in out's tree until in is
+// closed.
+func consume[T, U any](out *Channel[U], in <-chan T, workers int, fn func(ctx context.Context, value T, emit func(context.Context, U) error) error) {
+	var lock sync.Mutex // Orders receiving values with issuing tickets.
+	// receive the next value from in, returning a nil done function if in is
+	// closed.
+	receive := func(ctx context.Context) (value T, emit func(context.Context, U) error, done func(), err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return value, nil, nil, ctx.Err()
+
+		case value, ok := <-in:
+			if !ok {
+				return value, nil, nil, nil
+			}
+			emit, done := out.emitter()
+			return value, emit, done, nil
+		}
+	}
+	out.tree.GoN(workers, func(ctx context.Context, worker int) error {
+		for {
+			value, emit, done, err := receive(ctx)
+			if err != nil {
+				return err
+			} else if done == nil {
+				return nil
+			}
+			err = fn(ctx, value, emit)
+			done()
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// TeeDest is a destination channel for [Tee].
+type TeeDest[T any] struct {
+	Dest chan T
+	// Backpressure policy used when Dest is full.
+	Backpressure Backpressure
+	// OnDrop is called with values discarded by the Backpressure policy, if set.
+	OnDrop func(T)
+}
+
+// Tee sends every value received from src to each of dests in a function in
+// tree, until src is closed.
+//
+// Tee must be the only sender on dests, which are closed when it completes.
+func Tee[T any](tree *Tree, src <-chan T, dests ...TeeDest[T]) {
+	tree.Go(func(ctx context.Context) error {
+		defer func() {
+			for _, dest := range dests {
+				close(dest.Dest)
+			}
+		}()
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-src:
+				if !ok {
+					return nil
+				}
+				for _, dest := range dests {
+					if _, err := sendWith(ctx, dest.Dest, dest.Dest, value, dest.Backpressure, dest.OnDrop); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	})
+}
+
+// Reducer folds the values of a channel into a single value. See
+// [ReduceChannel].
+type Reducer[U any] struct {
+	tree  *Tree
+	value U
+}
+
+// ReduceChannel folds values received from in into initial with fn in a new
+// tree, until in is closed.
+//
+// fn is called serially, so the accumulator does not need to be synchronised.
+func ReduceChannel[T, U any](ctx context.Context, in <-chan T, initial U, fn func(U, T) U, options ...Option) *Reducer[U] {
+	tree, _ := New(ctx, options...)
+	r := &Reducer[U]{tree: tree, value: initial}
+	tree.Go(func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-in:
+				if !ok {
+					return nil
+				}
+				r.value = fn(r.value, value)
+			}
+		}
+	})
+	return r
+}
+
+// Wait for in to be closed and return the final value.
+func (r *Reducer[U]) Wait() (U, error) {
+	err := r.tree.Wait()
+	return r.value, err
+}
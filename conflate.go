@@ -0,0 +1,83 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+)
+
+// ConflateChannel starts a pipeline stage that forwards values received from
+// in to the returned channel, keeping only the latest pending value for each
+// key while the consumer lags.
+//
+// Pending keys are sent in the order they first became pending, with their
+// most recent value. A value that is already being sent is not replaced.
+// Channel options such as [WithEmitRate] apply to every value sent. The
+// returned channel is closed when Wait returns.
+func ConflateChannel[T any, K comparable](ctx context.Context, in <-chan T, key func(T) K, options ...Option) (*Channel[T], <-chan T) {
+	out, dest, _ := NewChannel[T](ctx, 0, options...)
+	var (
+		lock    sync.Mutex
+		pending = map[K]T{}
+		order   []K
+		closed  bool
+	)
+	ready := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+	out.tree.Go(func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-in:
+				lock.Lock()
+				if !ok {
+					closed = true
+				} else {
+					k := key(value)
+					if _, ok := pending[k]; !ok {
+						order = append(order, k)
+					}
+					pending[k] = value
+				}
+				lock.Unlock()
+				notify()
+				if !ok {
+					return nil
+				}
+			}
+		}
+	})
+	// Values are delivered with send so that channel options are applied.
+	out.tree.Go(func(ctx context.Context) error {
+		for {
+			lock.Lock()
+			if len(order) == 0 {
+				done := closed
+				lock.Unlock()
+				if done {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-ready:
+				}
+				continue
+			}
+			value := pending[order[0]]
+			delete(pending, order[0])
+			order = order[1:]
+			lock.Unlock()
+			if err := out.send(ctx, value); err != nil {
+				return err
+			}
+		}
+	})
+	return out, dest
+}
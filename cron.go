@@ -0,0 +1,221 @@
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleCron calls fn at each time matching the cron spec until it returns
+// an error, the schedule is stopped, or the tree is cancelled.
+//
+// spec is a standard five field cron expression (minute, hour, day of month,
+// month, day of week), optionally preceded by a seconds field. Fields support
+// "*", lists, ranges, steps, and month and weekday names. The descriptors
+// @yearly, @monthly, @weekly, @daily and @hourly are also supported.
+//
+// [InitialDelay] delays the first run to the first matching time after the
+// delay, and [MaxRuns] is supported. [FixedRate] has no effect. An error is
+// returned if spec is invalid.
+func ScheduleCron(tree *Tree, spec string, fn func(context.Context) error, options ...ScheduleOption) (*Scheduled, error) {
+	schedule, err := ParseCron(spec)
+	if err != nil {
+		return nil, err
+	}
+	opts := newScheduleOptions(options)
+	s := newScheduled()
+	first := schedule.Next(time.Now().Add(opts.initialDelay))
+	if first.IsZero() {
+		return s, nil
+	}
+	s.run(tree, opts, first, func(ctx context.Context, slot time.Time) (time.Time, bool, error) {
+		if err := fn(ctx); err != nil {
+			return time.Time{}, false, err
+		}
+		next := schedule.Next(time.Now())
+		return next, !next.IsZero(), nil
+	})
+	return s, nil
+}
+
+// CronSchedule is a parsed cron expression.
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+}
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var (
+	cronSeconds = cronField{0, 59, nil}
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDoms    = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	cronDows    = cronField{0, 6, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression as accepted by ScheduleCron.
+func ParseCron(spec string) (*CronSchedule, error) {
+	if expanded, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron %q: expected 5 or 6 fields but got %d", spec, len(fields))
+	}
+	var (
+		schedule CronSchedule
+		err      error
+	)
+	for i, parse := range []struct {
+		dest  *uint64
+		field cronField
+	}{
+		{&schedule.second, cronSeconds},
+		{&schedule.minute, cronMinutes},
+		{&schedule.hour, cronHours},
+		{&schedule.dom, cronDoms},
+		{&schedule.month, cronMonths},
+		{&schedule.dow, cronDows},
+	} {
+		*parse.dest, err = parse.field.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron %q: %w", spec, err)
+		}
+	}
+	// Sunday may also be written as 7.
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow = schedule.dow&^(1<<7) | 1
+	}
+	return &schedule, nil
+}
+
+func (f cronField) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepSpec)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+		var low, high int
+		switch {
+		case rangeSpec == "*":
+			low, high = f.min, f.max
+		case strings.Contains(rangeSpec, "-"):
+			lowSpec, highSpec, _ := strings.Cut(rangeSpec, "-")
+			var err error
+			if low, err = f.value(lowSpec); err != nil {
+				return 0, err
+			}
+			if high, err = f.value(highSpec); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if low, err = f.value(rangeSpec); err != nil {
+				return 0, err
+			}
+			high = low
+			if hasStep {
+				high = f.max
+			}
+		}
+		if low > high {
+			return 0, fmt.Errorf("invalid range %q", part)
+		}
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(spec string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(spec, name) {
+			return f.min + i, nil
+		}
+	}
+	value, err := strconv.Atoi(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", spec)
+	}
+	limit := f.max
+	if f.names != nil && f.min == 0 {
+		// Allow 7 for Sunday.
+		limit++
+	}
+	if value < f.min || value > limit {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", value, f.min, f.max)
+	}
+	return value, nil
+}
+
+// Next returns the first time after t matching the schedule, or the zero time
+// if there is none within the next five years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+		case s.second&(1<<uint(t.Second())) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// matchDay follows cron semantics: if both day of month and day of week are
+// restricted, either may match.
+func (s *CronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	domAll := s.dom == cronDoms.all()
+	dowAll := s.dow == cronDows.all()
+	if !domAll && !dowAll {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (f cronField) all() uint64 {
+	var bits uint64
+	for i := f.min; i <= f.max; i++ {
+		bits |= 1 << uint(i)
+	}
+	return bits
+}
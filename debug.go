@@ -0,0 +1,207 @@
+package concurrency
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrWaitInTask is returned when a tree is waited on from within one of its
+// own functions, which would otherwise deadlock.
+var ErrWaitInTask = errors.New("concurrency: Wait called from within tree task")
+
+// goroutineID returns the ID of the current goroutine.
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	// The stack starts with "goroutine <id> [".
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return id
+}
+
+// RunningTask describes a function that is running in a [Tree].
+type RunningTask struct {
+	Task    TaskInfo
+	Started time.Time
+	// Stack of the goroutine running the function, if requested.
+	Stack []byte
+}
+
+// WithHungTaskDiagnostics calls fn with the functions still running in the
+// tree and its sub-trees if Wait has been blocked for longer than threshold.
+//
+// If stacks is true the stack of each function's goroutine is included.
+func WithHungTaskDiagnostics(threshold time.Duration, stacks bool, fn func(running []RunningTask)) Option {
+	return func(o *Tree) {
+		o.hungThreshold = threshold
+		o.hungStacks = stacks
+		o.onHung = fn
+	}
+}
+
+// WithOnGoAfterWait calls fn instead of panicking when a function is submitted
+// to a cancelled tree after Wait has returned.
+//
+// The function is still submitted, but will run with a cancelled context.
+func WithOnGoAfterWait(fn func(err *GoAfterWaitError)) Option {
+	return func(o *Tree) {
+		o.onGoAfterWait = fn
+	}
+}
+
+// checkSubmit reports functions submitted to a cancelled tree after Wait has
+// returned.
+func (g *Tree) checkSubmit() {
+	if !g.waited.Load() || g.ctx.Err() == nil {
+		return
+	}
+	err := &GoAfterWaitError{}
+	err.File, err.Line = callSite()
+	if g.onGoAfterWait == nil {
+		panic(err)
+	}
+	g.onGoAfterWait(err)
+}
+
+// WithOnDiscard calls fn when a function submitted to a draining tree is
+// discarded. See [Tree.Drain].
+func WithOnDiscard(fn func(err *DiscardedError)) Option {
+	return func(o *Tree) {
+		o.onDiscard = fn
+	}
+}
+
+// discarded reports that a function of kind was discarded because the tree is
+// draining.
+func (g *Tree) discarded(kind TaskKind) {
+	if g.onDiscard == nil {
+		return
+	}
+	err := &DiscardedError{Kind: kind}
+	err.File, err.Line = callSite()
+	g.onDiscard(err)
+}
+
+// callSite returns the location of the first caller outside this package.
+func callSite() (file string, line int) {
+	_, self, _, _ := runtime.Caller(0)
+	dir := filepath.Dir(self)
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != dir || strings.HasSuffix(frame.File, "_test.go") || !more {
+			return frame.File, frame.Line
+		}
+	}
+}
+
+// running returns the functions currently running in the tree and its
+// sub-trees, ordered by start time.
+func (g *Tree) running(stacks bool) []RunningTask {
+	g.goroutinesLock.Lock()
+	ids := make(map[uint64]int, len(g.goroutines))
+	running := make([]RunningTask, 0, len(g.goroutines))
+	for id, task := range g.goroutines {
+		ids[id] = len(running)
+		running = append(running, *task)
+	}
+	g.goroutinesLock.Unlock()
+	if stacks {
+		for id, stack := range goroutineStacks() {
+			if i, ok := ids[id]; ok {
+				running[i].Stack = stack
+			}
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Started.Before(running[j].Started) })
+	return running
+}
+
+// goroutineStacks returns the stacks of all goroutines keyed by ID.
+func goroutineStacks() map[uint64][]byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	stacks := map[uint64][]byte{}
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		fields := bytes.Fields(stack)
+		if len(fields) < 2 {
+			continue
+		}
+		if id, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+			stacks[id] = stack
+		}
+	}
+	return stacks
+}
+
+// taskTrees maps the ID of each goroutine running a function to its tree.
+var taskTrees sync.Map
+
+// enter records that the current goroutine is running task in the tree, and
+// in those of its ancestors with hung task diagnostics enabled. The returned
+// function must be called when the function completes.
+func (g *Tree) enter(task TaskInfo) (exit func()) {
+	id := goroutineID()
+	prev, nested := taskTrees.Swap(id, g)
+	var (
+		diagnosed []*Tree
+		running   *RunningTask
+	)
+	for t := g; t != nil; t = t.parent {
+		if t.onHung == nil {
+			continue
+		}
+		if running == nil {
+			running = &RunningTask{Task: task, Started: time.Now()}
+		}
+		t.goroutinesLock.Lock()
+		t.goroutines[id] = running
+		t.goroutinesLock.Unlock()
+		diagnosed = append(diagnosed, t)
+	}
+	return func() {
+		for _, t := range diagnosed {
+			t.goroutinesLock.Lock()
+			delete(t.goroutines, id)
+			t.goroutinesLock.Unlock()
+		}
+		if nested {
+			taskTrees.Store(id, prev)
+		} else {
+			taskTrees.Delete(id)
+		}
+	}
+}
+
+// checkWait returns ErrWaitInTask if the current goroutine is running a
+// function in the tree or one of its descendants.
+func (g *Tree) checkWait() error {
+	value, ok := taskTrees.Load(goroutineID())
+	if !ok {
+		return nil
+	}
+	for t := value.(*Tree); t != nil; t = t.parent {
+		if t == g {
+			return ErrWaitInTask
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PanicError is returned when a function run by the package panics.
+type PanicError struct {
+	// Value passed to panic().
+	Value any
+	// Stack of the goroutine at the time of the panic.
+	Stack []byte
+}
+
+// newPanicError must be called from the deferred function that recovered r.
+func newPanicError(r any) *PanicError {
+	return &PanicError{Value: r, Stack: debug.Stack()}
+}
+
+func (p *PanicError) Error() string { return fmt.Sprintf("panic: %v", p.Value) }
+
+// Unwrap returns the panic value if it is an error.
+func (p *PanicError) Unwrap() error {
+	if err, ok := p.Value.(error); ok {
+		return err
+	}
+	return nil
+}
+
+// TaskTimeoutError is returned when a function fails after exceeding the
+// timeout set by [WithTaskTimeout].
+type TaskTimeoutError struct {
+	// Name of the task, if any.
+	Name string
+	// Index of the task in the order it was submitted to its tree.
+	Index int
+	// Timeout that was exceeded.
+	Timeout time.Duration
+	// Err is the error returned by the function.
+	Err error
+}
+
+func (t *TaskTimeoutError) Error() string {
+	task := strconv.Itoa(t.Index)
+	if t.Name != "" {
+		task = t.Name
+	}
+	return fmt.Sprintf("task %s timed out after %s: %s", task, t.Timeout, t.Err)
+}
+
+// Unwrap returns the function's error and [context.DeadlineExceeded].
+func (t *TaskTimeoutError) Unwrap() []error { return []error{t.Err, context.DeadlineExceeded} }
+
+// TreeTimeoutError is returned when a tree is cancelled by [WithDeadline] or
+// [WithTimeout].
+type TreeTimeoutError struct {
+	Deadline time.Time
+}
+
+func (t *TreeTimeoutError) Error() string {
+	return fmt.Sprintf("tree deadline of %s exceeded", t.Deadline.Format(time.RFC3339Nano))
+}
+
+// Unwrap returns [context.DeadlineExceeded].
+func (t *TreeTimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// TimeoutError is returned by [RunWithTimeout] when the function does not
+// complete within its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	// Elapsed is the time from starting the function until RunWithTimeout
+	// returned.
+	Elapsed time.Duration
+	// Done is closed once the function has returned, which may be after
+	// RunWithTimeout if it ignores cancellation.
+	Done <-chan struct{}
+}
+
+func (t *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s (timeout %s)", t.Elapsed, t.Timeout)
+}
+
+// Unwrap returns [context.DeadlineExceeded].
+func (t *TimeoutError) Unwrap() error { return context.DeadlineExceeded }
+
+// CancelledError is returned by [RunWithTimeout] when its context is done
+// before the function completes.
+type CancelledError struct {
+	// Err is the cause of the context.
+	Err error
+	// Done is closed once the function has returned, which may be after
+	// RunWithTimeout if it ignores cancellation.
+	Done <-chan struct{}
+}
+
+func (c *CancelledError) Error() string { return c.Err.Error() }
+
+// Unwrap returns the cause of the context.
+func (c *CancelledError) Unwrap() error { return c.Err }
+
+// GoAfterWaitError is reported when a function is submitted to a cancelled
+// tree after Wait has returned. See [WithOnGoAfterWait].
+type GoAfterWaitError struct {
+	// File and Line of the call that submitted the function.
+	File string
+	Line int
+}
+
+func (g *GoAfterWaitError) Error() string {
+	return fmt.Sprintf("concurrency: function submitted at %s:%d to cancelled tree after Wait returned", g.File, g.Line)
+}
+
+// DiscardedError is reported when a function submitted to a draining tree is
+// discarded. See [WithOnDiscard].
+type DiscardedError struct {
+	Kind TaskKind
+	// File and Line of the call that submitted the function.
+	File string
+	Line int
+}
+
+func (d *DiscardedError) Error() string {
+	return fmt.Sprintf("concurrency: %s function submitted at %s:%d to draining tree was discarded", d.Kind, d.File, d.Line)
+}
+
+// SignalError is the cause of a tree being cancelled by [WithSignals].
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (s *SignalError) Error() string { return fmt.Sprintf("received signal %s", s.Signal) }
+
+// TaskError is returned by functions in a tree created with [WithErrorPaths].
+type TaskError struct {
+	// Path of tree names from the root, followed by the task name if any.
+	Path []string
+	Err  error
+}
+
+func (t *TaskError) Error() string { return strings.Join(t.Path, "/") + ": " + t.Err.Error() }
+
+func (t *TaskError) Unwrap() error { return t.Err }
+
+// IndexedError is returned by [Map] and related functions, recording the index
+// of the value for which the function failed.
+type IndexedError struct {
+	Index int
+	Err   error
+}
+
+func (i *IndexedError) Error() string { return fmt.Sprintf("index %d: %s", i.Index, i.Err) }
+
+func (i *IndexedError) Unwrap() error { return i.Err }
+
+// IndexedErrors returns all IndexedErrors in err, including those combined
+// with [errors.Join] by [WithCollectErrors].
+func IndexedErrors(err error) []*IndexedError {
+	var out []*IndexedError
+	var walk func(err error)
+	walk = func(err error) {
+		switch err := err.(type) {
+		case *IndexedError:
+			out = append(out, err)
+		case interface{ Unwrap() []error }:
+			for _, err := range err.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(err.Unwrap())
+		}
+	}
+	walk(err)
+	return out
+}
+
+// PermanentError wraps an error that [Retry] should not retry.
+type PermanentError struct {
+	Err error
+}
+
+// Permanent wraps err so that [Retry] returns it immediately.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func (p *PermanentError) Error() string { return p.Err.Error() }
+
+func (p *PermanentError) Unwrap() error { return p.Err }
@@ -2,6 +2,9 @@ package concurrency
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 )
 
@@ -9,14 +12,22 @@ import (
 //
 // Order is preserved. Each call will run in a separate [Tree.Go]() so use
 // [WithConcurrencyLimit]() if necessary
+//
+// Errors from fn are wrapped in an [IndexedError]. Use [WithCollectErrors] to
+// return the errors for all failing values.
 func Map[U, T any](tree *Tree, values []U, fn func(context.Context, U) (T, error)) ([]T, error) {
+	return MapWithIndex(tree, values, func(ctx context.Context, index int, value U) (T, error) { return fn(ctx, value) })
+}
+
+// MapWithIndex is like [Map], but fn is also passed the index of each value.
+func MapWithIndex[U, T any](tree *Tree, values []U, fn func(ctx context.Context, index int, value U) (T, error)) ([]T, error) {
 	out := make([]T, len(values))
 	for i, value := range values {
 		i, value := i, value
 		tree.Go(func(ctx context.Context) error {
-			result, err := fn(ctx, value)
+			result, err := fn(ctx, i, value)
 			if err != nil {
-				return err
+				return &IndexedError{Index: i, Err: err}
 			}
 			out[i] = result
 			return nil
@@ -25,34 +36,569 @@ func Map[U, T any](tree *Tree, values []U, fn func(context.Context, U) (T, error
 	return out, tree.Wait()
 }
 
-// Schedule calls fn every time interval until it returns an error or the
-// context is cancelled.
-func Schedule(tree *Tree, fn func(context.Context) (time.Duration, error)) error {
-	tree.Go(func(ctx context.Context) error {
-		var delay time.Duration
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
+// MapM runs fn in tree for each key and value in values, and returns the
+// results by key.
+//
+// As with [Map], each call runs in a separate [Tree.Go].
+func MapM[K comparable, V, R any](tree *Tree, values map[K]V, fn func(context.Context, K, V) (R, error)) (map[K]R, error) {
+	var lock sync.Mutex
+	out := make(map[K]R, len(values))
+	for key, value := range values {
+		key, value := key, value
+		tree.Go(func(ctx context.Context) error {
+			result, err := fn(ctx, key, value)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			out[key] = result
+			return nil
+		})
+	}
+	if err := tree.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ForEach runs fn in tree for each value in values, and waits for the tree.
+//
+// As with [Map], each call runs in a separate [Tree.Go].
+func ForEach[T any](tree *Tree, values []T, fn func(context.Context, T) error) error {
+	for _, value := range values {
+		value := value
+		tree.Go(func(ctx context.Context) error { return fn(ctx, value) })
+	}
+	return tree.Wait()
+}
 
-			case <-time.After(delay):
-				var err error
-				delay, err = fn(ctx)
+// TryMap is like [Map], but failures do not cancel the tree. The results for
+// all values are returned, with zero values for those that failed, along with
+// an [IndexedError] for each failure combined with [errors.Join].
+//
+// Use [IndexedErrors] to retrieve the individual errors.
+func TryMap[U, T any](tree *Tree, values []U, fn func(context.Context, U) (T, error)) ([]T, error) {
+	errs := make([]error, len(values))
+	out, err := MapWithIndex(tree, values, func(ctx context.Context, index int, value U) (T, error) {
+		result, err := fn(ctx, value)
+		if err != nil {
+			errs[index] = &IndexedError{Index: index, Err: err}
+		}
+		return result, nil
+	})
+	return out, errors.Join(append(errs, err)...)
+}
+
+// Filter runs pred in tree for each value in values, and returns the values for
+// which it returned true.
+//
+// Order is preserved. As with [Map], each call runs in a separate [Tree.Go].
+func Filter[T any](tree *Tree, values []T, pred func(context.Context, T) (bool, error)) ([]T, error) {
+	matched, err := Map(tree, values, pred)
+	if err != nil {
+		return nil, err
+	}
+	out := []T{}
+	for i, value := range values {
+		if matched[i] {
+			out = append(out, value)
+		}
+	}
+	return out, nil
+}
+
+// Partition runs pred in tree for each value in values, and returns the values
+// for which it returned true and false respectively.
+//
+// Order is preserved. As with [Map], each call runs in a separate [Tree.Go].
+func Partition[T any](tree *Tree, values []T, pred func(context.Context, T) (bool, error)) (matched, rest []T, err error) {
+	results, err := Map(tree, values, pred)
+	if err != nil {
+		return nil, nil, err
+	}
+	matched, rest = []T{}, []T{}
+	for i, value := range values {
+		if results[i] {
+			matched = append(matched, value)
+		} else {
+			rest = append(rest, value)
+		}
+	}
+	return matched, rest, nil
+}
+
+// GroupBy runs key in tree for each value in values, and returns the values
+// grouped by their key.
+//
+// The order of values within each group is preserved. As with [Map], each call
+// runs in a separate [Tree.Go].
+func GroupBy[T any, K comparable](tree *Tree, values []T, key func(context.Context, T) (K, error)) (map[K][]T, error) {
+	keys, err := Map(tree, values, key)
+	if err != nil {
+		return nil, err
+	}
+	out := map[K][]T{}
+	for i, value := range values {
+		out[keys[i]] = append(out[keys[i]], value)
+	}
+	return out, nil
+}
+
+// Any runs pred in tree for each value in values, returning true as soon as
+// any call returns true and cancelling the tree.
+func Any[T any](tree *Tree, values []T, pred func(context.Context, T) (bool, error)) (bool, error) {
+	return find(tree, values, pred, true)
+}
+
+// All runs pred in tree for each value in values, returning false as soon as
+// any call returns false and cancelling the tree.
+func All[T any](tree *Tree, values []T, pred func(context.Context, T) (bool, error)) (bool, error) {
+	found, err := find(tree, values, pred, false)
+	if err != nil {
+		return false, err
+	}
+	return !found, nil
+}
+
+var errFound = errors.New("concurrency: found")
+
+// find returns true as soon as pred returns want for any value.
+func find[T any](tree *Tree, values []T, pred func(context.Context, T) (bool, error), want bool) (bool, error) {
+	for _, value := range values {
+		value := value
+		tree.Go(func(ctx context.Context) error {
+			result, err := pred(ctx, value)
+			if err != nil {
+				return err
+			}
+			if result == want {
+				tree.Cancel(errFound)
+			}
+			return nil
+		})
+	}
+	err := tree.Wait()
+	if errors.Is(err, errFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// FlatMap runs fn in tree for each value in values, and returns the
+// concatenation of the results.
+//
+// Order is preserved. As with [Map], each call runs in a separate [Tree.Go].
+func FlatMap[U, T any](tree *Tree, values []U, fn func(context.Context, U) ([]T, error)) ([]T, error) {
+	results, err := Map(tree, values, fn)
+	if err != nil {
+		return nil, err
+	}
+	out := []T{}
+	for _, result := range results {
+		out = append(out, result...)
+	}
+	return out, nil
+}
+
+// Reduce runs mapper in tree for each value in values, then combines the
+// results pairwise in parallel until one remains, which is returned.
+//
+// combine must be associative, but need not be commutative as the order of
+// values is preserved. If values is empty the zero value of U is returned.
+func Reduce[T, U any](tree *Tree, values []T, mapper func(context.Context, T) (U, error), combine func(U, U) U) (U, error) {
+	var result U
+	// The phases are chained from within tasks so that the tree is only waited
+	// once.
+	var reduce func(lo, hi int, done func(U))
+	reduce = func(lo, hi int, done func(U)) {
+		if hi-lo == 1 {
+			tree.Go(func(ctx context.Context) error {
+				value, err := mapper(ctx, values[lo])
+				if err != nil {
+					return &IndexedError{Index: lo, Err: err}
+				}
+				done(value)
+				return nil
+			})
+			return
+		}
+		var (
+			lock        sync.Mutex
+			left, right U
+			pending     = 2
+		)
+		// join records one half, combining both once they are available.
+		join := func(half *U) func(U) {
+			return func(value U) {
+				lock.Lock()
+				*half = value
+				pending--
+				last := pending == 0
+				lock.Unlock()
+				if last {
+					tree.goUnqueued(func(ctx context.Context) error {
+						done(combine(left, right))
+						return nil
+					})
+				}
+			}
+		}
+		mid := (lo + hi) / 2
+		reduce(lo, mid, join(&left))
+		reduce(mid, hi, join(&right))
+	}
+	if len(values) > 0 {
+		reduce(0, len(values), func(value U) { result = value })
+	}
+	if err := tree.Wait(); err != nil {
+		var zero U
+		return zero, err
+	}
+	return result, nil
+}
+
+// MapReduce runs mapper in tree for each value in values, grouping the
+// intermediate values passed to emit by key, then runs reducer in tree for
+// each key.
+//
+// Intermediate values for each key are passed to reducer in the order of values
+// they were emitted for. Parallelism of both phases is controlled by the
+// tree's concurrency limit.
+func MapReduce[T any, K comparable, V, R any](
+	tree *Tree,
+	values []T,
+	mapper func(ctx context.Context, value T, emit func(K, V)) error,
+	reducer func(ctx context.Context, key K, values []V) (R, error),
+) (map[K]R, error) {
+	type pair struct {
+		key   K
+		value V
+	}
+	var lock sync.Mutex
+	emitted := make([][]pair, len(values))
+	pending := len(values)
+	out := map[K]R{}
+	// reduce starts the reduce phase once every mapper has succeeded, so that
+	// the tree is only waited once.
+	reduce := func() {
+		groups := map[K][]V{}
+		for _, pairs := range emitted {
+			for _, pair := range pairs {
+				groups[pair.key] = append(groups[pair.key], pair.value)
+			}
+		}
+		for key, group := range groups {
+			key, group := key, group
+			tree.goUnqueued(func(ctx context.Context) error {
+				result, err := reducer(ctx, key, group)
 				if err != nil {
 					return err
 				}
+				lock.Lock()
+				defer lock.Unlock()
+				out[key] = result
+				return nil
+			})
+		}
+	}
+	for i, value := range values {
+		i, value := i, value
+		tree.Go(func(ctx context.Context) error {
+			var pairs []pair
+			err := mapper(ctx, value, func(key K, value V) { pairs = append(pairs, pair{key, value}) })
+			if err != nil {
+				return &IndexedError{Index: i, Err: err}
+			}
+			lock.Lock()
+			emitted[i] = pairs
+			pending--
+			last := pending == 0
+			lock.Unlock()
+			if last {
+				reduce()
+			}
+			return nil
+		})
+	}
+	if err := tree.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MapChunked runs fn in tree for each consecutive chunk of up to chunkSize
+// values, and returns the concatenated results.
+//
+// Order is preserved. Each chunk runs in a separate [Tree.Go]. Errors are
+// wrapped in an [IndexedError] with the index of the first value in the chunk.
+//
+// An error is returned without calling fn if chunkSize is not positive.
+func MapChunked[U, T any](tree *Tree, values []U, chunkSize int, fn func(context.Context, []U) ([]T, error)) ([]T, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("concurrency: chunk size must be positive but is %d", chunkSize)
+	}
+	results := make([][]T, (len(values)+chunkSize-1)/chunkSize)
+	for i := range results {
+		i := i
+		start := i * chunkSize
+		chunk := values[start:min(start+chunkSize, len(values))]
+		tree.Go(func(ctx context.Context) error {
+			result, err := fn(ctx, chunk)
+			if err != nil {
+				return &IndexedError{Index: start, Err: err}
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	if err := tree.Wait(); err != nil {
+		return nil, err
+	}
+	out := []T{}
+	for _, result := range results {
+		out = append(out, result...)
+	}
+	return out, nil
+}
+
+// First runs each of fns concurrently in a new tree, returning the first
+// successful result and cancelling the remaining functions.
+//
+// If all functions fail their errors are returned, combined with
+// [errors.Join].
+func First[T any](ctx context.Context, fns ...func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if len(fns) == 0 {
+		return zero, errors.New("concurrency: First called with no functions")
+	}
+	tree, _ := New(ctx, WithContinueOnError())
+	var once sync.Once
+	var result T
+	found := false
+	for _, fn := range fns {
+		fn := fn
+		tree.Go(func(ctx context.Context) error {
+			value, err := fn(ctx)
+			if err != nil {
+				return err
 			}
+			once.Do(func() {
+				result, found = value, true
+				tree.Cancel(context.Canceled)
+			})
+			return nil
+		})
+	}
+	err := tree.Wait()
+	if found {
+		return result, nil
+	}
+	return zero, err
+}
+
+// SubResult calls fn in a new sub-tree as with [Tree.Sub], and returns a
+// [Future] that resolves to its result once the sub-tree has completed.
+//
+// If the tree is draining the Future resolves immediately with a
+// [DiscardedError].
+func SubResult[T any](tree *Tree, fn func(context.Context, *Tree) (T, error), options ...Option) *Future[T] {
+	future := newFuture[T]()
+	accepted := tree.sub("", func(ctx context.Context, sub *Tree) (err error) {
+		var value T
+		// Registered once fn has returned or panicked, as fn may itself wait the
+		// sub-tree.
+		defer func() { sub.Defer(func(err error) { future.resolve(value, err) }) }()
+		value, err = fn(ctx, sub)
+		return err
+	}, func(err error) {
+		var zero T
+		future.resolve(zero, err)
+	}, options...)
+	if !accepted {
+		err := &DiscardedError{Kind: TaskSub}
+		err.File, err.Line = callSite()
+		var zero T
+		future.resolve(zero, err)
+	}
+	return future
+}
+
+// Limit returns a function wrapping fn that at most n callers can execute
+// concurrently. Other callers block until a slot is free or their context is
+// done, in which case the context error is returned. If n is zero the number
+// of callers is unlimited.
+func Limit[A, R any](fn func(context.Context, A) (R, error), n int) func(context.Context, A) (R, error) {
+	limiter := newLimiter(int64(n))
+	return func(ctx context.Context, arg A) (R, error) {
+		if err := limiter.Acquire(ctx, 1, 0); err != nil {
+			var zero R
+			return zero, err
 		}
-	})
-	return nil
+		defer limiter.Release(1)
+		return fn(ctx, arg)
+	}
 }
 
 // Call runs fn in a separate goroutine and returns a context that will cancel
 // when the function completes.
+//
+// A panic in fn is recovered and becomes the cause of the context as a
+// [PanicError].
 func Call(ctx context.Context, fn func() error) context.Context {
 	ctx, cancel := context.WithCancelCause(ctx)
 	go func() {
-		cancel(fn())
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+			cancel(err)
+		}()
+		err = fn()
 	}()
 	return ctx
 }
+
+// RunWithTimeout runs fn in a separate goroutine with a context that is
+// cancelled after timeout, returning a [TimeoutError] if it has not completed
+// by then.
+//
+// RunWithTimeout returns at the deadline even if fn ignores cancellation, in
+// which case the goroutine keeps running until fn returns. Receive from
+// [TimeoutError.Done] to wait for it. If ctx is done first, a [CancelledError]
+// wrapping its cause is returned, with the same Done channel. A panic in fn is
+// recovered as a [PanicError].
+func RunWithTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) error) error {
+	start := time.Now()
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// Buffered so the goroutine can always complete after we stop waiting.
+	done := make(chan error, 1)
+	returned := make(chan struct{})
+	go func() {
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+			done <- err
+			close(returned)
+		}()
+		err = fn(ctx)
+	}()
+	// completed returns the result of fn once it has returned.
+	completed := func(err error) error {
+		if err != nil && parent.Err() == nil && errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+			return &TimeoutError{Timeout: timeout, Elapsed: time.Since(start), Done: returned}
+		}
+		return err
+	}
+	select {
+	case err := <-done:
+		return completed(err)
+
+	case <-ctx.Done():
+		// fn may have returned at the same time.
+		select {
+		case err := <-done:
+			return completed(err)
+		default:
+		}
+		if parent.Err() != nil {
+			return &CancelledError{Err: context.Cause(parent), Done: returned}
+		}
+		return &TimeoutError{Timeout: timeout, Elapsed: time.Since(start), Done: returned}
+	}
+}
+
+// CallValue runs fn in a separate goroutine and returns a [Future] for its
+// result.
+//
+// If ctx is done before fn completes, the Future resolves with the cause of
+// ctx instead, distinguishing cancellation from fn failing. A panic in fn is
+// recovered as a [PanicError].
+func CallValue[T any](ctx context.Context, fn func() (T, error)) *Future[T] {
+	future := newFuture[T]()
+	var once sync.Once
+	stop := context.AfterFunc(ctx, func() {
+		once.Do(func() {
+			var zero T
+			future.resolve(zero, context.Cause(ctx))
+		})
+	})
+	go func() {
+		var (
+			value T
+			err   error
+		)
+		defer func() {
+			if r := recover(); r != nil {
+				err = newPanicError(r)
+			}
+			stop()
+			once.Do(func() { future.resolve(value, err) })
+		}()
+		value, err = fn()
+	}()
+	return future
+}
+
+// FromChannel runs workers goroutines in tree that call fn with each value
+// received from src, until src is closed or the tree is cancelled.
+func FromChannel[T any](tree *Tree, src <-chan T, workers int, fn func(context.Context, T) error) {
+	tree.GoN(workers, func(ctx context.Context, worker int) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-src:
+				if !ok {
+					return nil
+				}
+				if err := fn(ctx, value); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// Generate runs fn in a new tree, returning a channel that receives each value
+// passed to yield and is closed when fn returns.
+//
+// yield blocks until the value is received, returning an error if the tree is
+// cancelled first. The returned Waiter reports the error from fn.
+func Generate[T any](ctx context.Context, fn func(ctx context.Context, yield func(T) error) error, options ...Option) (<-chan T, Waiter) {
+	out, dest, _ := NewChannel[T](ctx, 0, options...)
+	out.GoMany(func(ctx context.Context, emit func(T) error) error {
+		defer out.close.Do(func() { close(out.recv) })
+		return fn(ctx, emit)
+	})
+	return dest, out
+}
+
+// Collect appends values received from src to the returned slice in a function
+// in tree, until src is closed.
+//
+// The slice must not be read until tree.Wait has returned.
+func Collect[T any](tree *Tree, src <-chan T) *[]T {
+	out := &[]T{}
+	tree.Go(func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-src:
+				if !ok {
+					return nil
+				}
+				*out = append(*out, value)
+			}
+		}
+	})
+	return out
+}
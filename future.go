@@ -0,0 +1,42 @@
+package concurrency
+
+import (
+	"context"
+)
+
+// A Future is a value that will be available once an asynchronous computation
+// completes.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+// resolve must be called exactly once.
+func (f *Future[T]) resolve(value T, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel that is closed when the result is available.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for and returns the result, or returns the error from ctx if it is
+// done first.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
@@ -1,11 +1,8 @@
 module github.com/alecthomas/concurrency
 
-go 1.20
+go 1.21
 
-require (
-	github.com/alecthomas/assert/v2 v2.4.0
-	golang.org/x/sync v0.5.0
-)
+require github.com/alecthomas/assert/v2 v2.4.0
 
 require (
 	github.com/alecthomas/repr v0.3.0 // indirect
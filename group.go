@@ -0,0 +1,88 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Group adapts a [Tree] to the method set of golang.org/x/sync/errgroup.Group,
+// so that code using errgroup can switch to a Tree by changing types.
+//
+// As with errgroup, the zero value is usable and does not cancel on error, and
+// a Group may be reused after Wait. Once a function has failed, the tree is
+// cancelled, so functions subsequently passed to Go may not be called if a
+// concurrency limit is set.
+type Group struct {
+	once    sync.Once
+	tree    *Tree
+	cancel  context.CancelFunc // Cancels the context returned by NewGroup.
+	blocked atomic.Bool        // SetLimit(0) was called.
+}
+
+// newGroupTree creates the tree underlying a Group. Functions may be
+// submitted after Wait, as with errgroup.
+func newGroupTree(ctx context.Context, options ...Option) (*Tree, context.Context) {
+	options = append([]Option{WithOnGoAfterWait(func(*GoAfterWaitError) {})}, options...)
+	return New(ctx, options...)
+}
+
+// NewGroup creates a new [Group], as with errgroup.WithContext.
+//
+// The returned context is cancelled when a function returns an error or the
+// first time Wait returns. As the Group may be reused, the underlying tree is
+// not released until ctx is done.
+func NewGroup(ctx context.Context, options ...Option) (*Group, context.Context) {
+	tree, ctx := newGroupTree(ctx, options...)
+	ctx, cancel := context.WithCancel(ctx)
+	g := &Group{tree: tree, cancel: cancel}
+	g.once.Do(func() {})
+	return g, ctx
+}
+
+// Tree returns the underlying [Tree].
+func (g *Group) Tree() *Tree {
+	g.once.Do(func() { g.tree, _ = newGroupTree(context.Background()) })
+	return g.tree
+}
+
+// Go calls fn in a new goroutine.
+//
+// As with errgroup, Go blocks forever if the limit has been set to 0.
+func (g *Group) Go(fn func() error) {
+	if g.blocked.Load() {
+		select {}
+	}
+	g.Tree().GoNoCtx(fn)
+}
+
+// TryGo calls fn in a new goroutine only if the concurrency limit allows it,
+// returning whether fn was started.
+func (g *Group) TryGo(fn func() error) bool {
+	if g.blocked.Load() {
+		return false
+	}
+	return g.Tree().TryGo(func(context.Context) error { return fn() })
+}
+
+// SetLimit limits the number of active goroutines to n. A negative value
+// disables the limit, and 0 prevents any new goroutines from starting.
+//
+// Unlike errgroup, the limit may be changed while goroutines are active.
+func (g *Group) SetLimit(n int) {
+	g.blocked.Store(n == 0)
+	if n < 0 {
+		n = 0
+	}
+	g.Tree().SetConcurrencyLimit(n)
+}
+
+// Wait blocks until all functions have returned, then returns the first
+// error, if any.
+func (g *Group) Wait() error {
+	err := g.Tree().Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package concurrency
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// NoJitter is a jitter function that never delays.
+func NoJitter() time.Duration { return 0 }
+
+// UniformJitter returns a jitter function for [WithJitter] that delays by a
+// random duration in the range [minDelay, maxDelay).
+func UniformJitter(minDelay, maxDelay time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return minDelay + randDuration(maxDelay-minDelay)
+	}
+}
+
+// RampJitter returns a jitter function for [WithJitterFunc] that delays each
+// function by step multiplied by its index, ramping up the number of running
+// functions over time.
+func RampJitter(step time.Duration) func(ctx context.Context, task TaskInfo) time.Duration {
+	return func(ctx context.Context, task TaskInfo) time.Duration {
+		return step * time.Duration(task.Index)
+	}
+}
+
+// DecorrelatedJitter returns a jitter function for [WithJitter] that delays by
+// a random duration between base and three times the previous delay, capped at
+// maxDelay.
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitter(base, maxDelay time.Duration) func() time.Duration {
+	var lock sync.Mutex
+	prev := base
+	return func() time.Duration {
+		lock.Lock()
+		defer lock.Unlock()
+		prev = decorrelated(base, maxDelay, prev)
+		return prev
+	}
+}
+
+func decorrelated(base, maxDelay, prev time.Duration) time.Duration {
+	return min(maxDelay, base+randDuration(prev*3-base))
+}
+
+// randDuration returns a random duration in the range [0, n), or 0 if n <= 0.
+func randDuration(n time.Duration) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(n))) //nolint:gosec
+}
@@ -0,0 +1,254 @@
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter is a weighted semaphore whose size can be changed while in use.
+//
+// A size of 0 disables the limit. Waiters are served in priority order, then
+// FIFO. A request for more than the size of the limiter is granted once nothing
+// else is held.
+type limiter struct {
+	lock    sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List
+}
+
+type waiter struct {
+	n        int64
+	priority int
+	ready    chan struct{}
+}
+
+func newLimiter(size int64) *limiter {
+	return &limiter{size: size}
+}
+
+// Acquire n slots, blocking until they are available or ctx is done.
+//
+// Waiters with a higher priority are granted slots first.
+func (l *limiter) Acquire(ctx context.Context, n int64, priority int) error {
+	l.lock.Lock()
+	if l.waiters.Len() == 0 && l.fits(n) {
+		l.cur += n
+		l.lock.Unlock()
+		return nil
+	}
+	w := &waiter{n: n, priority: priority, ready: make(chan struct{})}
+	var elem *list.Element
+	for e := l.waiters.Back(); e != nil; e = e.Prev() {
+		if e.Value.(*waiter).priority >= priority {
+			elem = l.waiters.InsertAfter(w, e)
+			break
+		}
+	}
+	if elem == nil {
+		elem = l.waiters.PushFront(w)
+	}
+	l.lock.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+
+	case <-ctx.Done():
+		l.lock.Lock()
+		select {
+		case <-w.ready:
+			// Acquired after cancellation, give the slots back.
+			l.cur -= n
+		default:
+			l.waiters.Remove(elem)
+		}
+		l.notify()
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire n slots without blocking, returning false if they are not
+// available.
+func (l *limiter) TryAcquire(n int64) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.waiters.Len() == 0 && l.fits(n) {
+		l.cur += n
+		return true
+	}
+	return false
+}
+
+// Release n previously acquired slots.
+func (l *limiter) Release(n int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.cur -= n
+	l.notify()
+}
+
+// Resize the limiter. Slots that are already held are unaffected.
+func (l *limiter) Resize(size int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.size = size
+	l.notify()
+}
+
+// Size returns the current size of the limiter.
+func (l *limiter) Size() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.size
+}
+
+func (l *limiter) fits(n int64) bool {
+	return l.size == 0 || l.cur == 0 || l.cur+n <= l.size
+}
+
+// notify wakes waiters in order for as long as they fit. Must be called with
+// the lock held.
+func (l *limiter) notify() {
+	for {
+		next := l.waiters.Front()
+		if next == nil {
+			return
+		}
+		w := next.Value.(*waiter)
+		if !l.fits(w.n) {
+			return
+		}
+		l.cur += w.n
+		l.waiters.Remove(next)
+		close(w.ready)
+	}
+}
+
+// rateLimiter is a token bucket.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64 // Tokens per second.
+	burst  float64
+	tokens float64 // May be negative if tokens have been reserved.
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Allow takes a token if one is immediately available.
+func (r *rateLimiter) Allow() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.refill()
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Wait for a token to become available, or ctx to be done.
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	r.lock.Lock()
+	r.refill()
+	r.tokens--
+	delay := time.Duration(-r.tokens / r.rate * float64(time.Second))
+	r.lock.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		// Return the reserved token.
+		r.lock.Lock()
+		r.tokens++
+		r.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// refill must be called with the lock held.
+func (r *rateLimiter) refill() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+}
+
+// sequencer admits holders of tickets strictly in the order the tickets were
+// issued.
+type sequencer struct {
+	lock    sync.Mutex
+	next    uint64 // Next ticket to issue.
+	turn    uint64 // Ticket currently admitted.
+	done    map[uint64]bool
+	waiting map[uint64]chan struct{}
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{done: map[uint64]bool{}, waiting: map[uint64]chan struct{}{}}
+}
+
+// Ticket issues the next ticket.
+func (s *sequencer) Ticket() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ticket := s.next
+	s.next++
+	return ticket
+}
+
+// Wait until it is ticket's turn, or ctx is done.
+func (s *sequencer) Wait(ctx context.Context, ticket uint64) error {
+	s.lock.Lock()
+	if s.turn == ticket {
+		s.lock.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	s.waiting[ticket] = ready
+	s.lock.Unlock()
+	select {
+	case <-ready:
+		return nil
+
+	case <-ctx.Done():
+		s.lock.Lock()
+		delete(s.waiting, ticket)
+		s.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Done marks ticket as finished, admitting the next ticket once all earlier
+// tickets are also finished. Done must be called exactly once for every
+// ticket, whether or not Wait succeeded.
+func (s *sequencer) Done(ticket uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.done[ticket] = true
+	for s.done[s.turn] {
+		delete(s.done, s.turn)
+		s.turn++
+	}
+	if ready, ok := s.waiting[s.turn]; ok {
+		delete(s.waiting, s.turn)
+		close(ready)
+	}
+}
@@ -0,0 +1,90 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// LogLevels configures the level at which each tree event is logged by
+// [WithLogger].
+type LogLevels struct {
+	// Start of a function.
+	Start slog.Level
+	// Done is successful completion of a function.
+	Done slog.Level
+	// Error returned by a function.
+	Error slog.Level
+	// Panic in a function.
+	Panic slog.Level
+	// Cancel of the tree.
+	Cancel slog.Level
+}
+
+// DefaultLogLevels used by [WithLogger].
+var DefaultLogLevels = LogLevels{
+	Start:  slog.LevelDebug,
+	Done:   slog.LevelDebug,
+	Error:  slog.LevelError,
+	Panic:  slog.LevelError,
+	Cancel: slog.LevelInfo,
+}
+
+// WithLogger logs the start and completion of functions, errors, panics and
+// the cause of the tree being cancelled to logger.
+//
+// Events are logged at [DefaultLogLevels] unless overridden with
+// [WithLogLevels].
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Tree) {
+		o.logger = logger
+	}
+}
+
+// WithLogLevels sets the levels at which events are logged by [WithLogger].
+func WithLogLevels(levels LogLevels) Option {
+	return func(o *Tree) {
+		o.logLevels = levels
+	}
+}
+
+func (g *Tree) logTaskStart(name string) {
+	if g.logger == nil {
+		return
+	}
+	g.log(g.logLevels.Start, "Task started", slog.String("task", name))
+}
+
+func (g *Tree) logTaskDone(name string, err error, duration time.Duration) {
+	if g.logger == nil {
+		return
+	}
+	var perr *PanicError
+	switch {
+	case err == nil:
+		g.log(g.logLevels.Done, "Task completed", slog.String("task", name), slog.Duration("duration", duration))
+
+	case errors.As(err, &perr):
+		g.log(g.logLevels.Panic, "Task panicked", slog.String("task", name), slog.Duration("duration", duration),
+			slog.Any("error", err), slog.String("stack", string(perr.Stack)))
+
+	default:
+		g.log(g.logLevels.Error, "Task failed", slog.String("task", name), slog.Duration("duration", duration),
+			slog.Any("error", err))
+	}
+}
+
+func (g *Tree) logCancel() {
+	if g.logger == nil {
+		return
+	}
+	g.log(g.logLevels.Cancel, "Tree cancelled", slog.Any("cause", context.Cause(g.ctx)))
+}
+
+func (g *Tree) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if g.name != "" {
+		attrs = append(attrs, slog.String("tree", g.name))
+	}
+	g.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
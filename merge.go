@@ -0,0 +1,68 @@
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+)
+
+// MergeSorted starts a pipeline stage that merges values from srcs, each of
+// which must already be sorted by less, sending them to the returned channel
+// in sorted order.
+//
+// The returned channel is closed when Wait returns.
+func MergeSorted[T any](ctx context.Context, less func(a, b T) bool, srcs ...<-chan T) (*Channel[T], <-chan T) {
+	out, dest, _ := NewChannel[T](ctx, 0)
+	out.tree.Go(func(ctx context.Context) error {
+		h := &mergeHeap[T]{less: less}
+		// next value from src, if any, is pushed onto the heap.
+		next := func(src <-chan T) error {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case value, ok := <-src:
+				if ok {
+					heap.Push(h, mergeEntry[T]{value: value, src: src})
+				}
+				return nil
+			}
+		}
+		for _, src := range srcs {
+			if err := next(src); err != nil {
+				return err
+			}
+		}
+		for h.Len() > 0 {
+			entry := heap.Pop(h).(mergeEntry[T])
+			if err := out.send(ctx, entry.value); err != nil {
+				return err
+			}
+			if err := next(entry.src); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return out, dest
+}
+
+type mergeEntry[T any] struct {
+	value T
+	src   <-chan T
+}
+
+type mergeHeap[T any] struct {
+	less    func(a, b T) bool
+	entries []mergeEntry[T]
+}
+
+func (h *mergeHeap[T]) Len() int           { return len(h.entries) }
+func (h *mergeHeap[T]) Less(i, j int) bool { return h.less(h.entries[i].value, h.entries[j].value) }
+func (h *mergeHeap[T]) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *mergeHeap[T]) Push(x any)         { h.entries = append(h.entries, x.(mergeEntry[T])) }
+
+func (h *mergeHeap[T]) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
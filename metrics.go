@@ -0,0 +1,43 @@
+package concurrency
+
+import (
+	"time"
+)
+
+// Metrics receives events from a [Tree], allowing them to be exported to a
+// metrics system.
+//
+// Methods may be called concurrently.
+type Metrics interface {
+	// TaskStarted is called when a function starts running.
+	TaskStarted()
+	// TaskFinished is called when a function completes, with how long it ran
+	// for and the error it returned, if any.
+	TaskFinished(duration time.Duration, err error)
+	// LimitBlocked is called when a function had to wait for the concurrency
+	// limit, with how long it waited.
+	LimitBlocked(duration time.Duration)
+	// TreeCancelled is called when the tree is cancelled.
+	TreeCancelled(cause error)
+}
+
+// ChannelMetrics may also be implemented by the [Metrics] of a [Channel]'s
+// tree to receive events from the Channel.
+type ChannelMetrics interface {
+	// ValueSent is called when a value is sent to the destination channel,
+	// with how long the send was blocked.
+	ValueSent(blocked time.Duration)
+	// ValueDropped is called when a value is discarded by the [Backpressure]
+	// policy.
+	ValueDropped()
+}
+
+// WithMetrics reports events from the tree to metrics.
+//
+// If metrics implements [ChannelMetrics] it also receives events from any
+// [Channel] using the tree.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *Tree) {
+		o.metrics = metrics
+	}
+}
@@ -0,0 +1,22 @@
+module github.com/alecthomas/concurrency/otelconcurrency
+
+go 1.21
+
+require (
+	github.com/alecthomas/assert/v2 v2.4.0
+	github.com/alecthomas/concurrency v0.0.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+replace github.com/alecthomas/concurrency => ../
+
+require (
+	github.com/alecthomas/repr v0.3.0 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/hexops/gotextdiff v1.0.3 // indirect
+	go.opentelemetry.io/otel/metric v1.24.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+)
@@ -0,0 +1,76 @@
+// Package otelconcurrency provides OpenTelemetry tracing for concurrency
+// trees.
+package otelconcurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/alecthomas/concurrency"
+)
+
+const instrumentationName = "github.com/alecthomas/concurrency/otelconcurrency"
+
+type config struct {
+	provider trace.TracerProvider
+}
+
+// An Option configures tracing.
+type Option func(*config)
+
+// WithTracerProvider sets the TracerProvider used to create spans.
+//
+// The global TracerProvider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithTracing starts a span for every function run by a tree.
+//
+// Spans for functions in a sub-tree are children of the span for the
+// [concurrency.Tree.Sub] call that created it. Errors are recorded on the span,
+// and panics are additionally recorded as a "panic" event with the stack trace.
+func WithTracing(options ...Option) concurrency.Option {
+	cfg := &config{provider: otel.GetTracerProvider()}
+	for _, option := range options {
+		option(cfg)
+	}
+	tracer := cfg.provider.Tracer(instrumentationName)
+	return concurrency.WithInterceptor(func(ctx context.Context, task concurrency.TaskInfo, next func(context.Context) error) error {
+		ctx, span := tracer.Start(ctx, spanName(task), trace.WithAttributes(
+			attribute.String("concurrency.tree", task.Tree),
+			attribute.String("concurrency.task.name", task.Name),
+			attribute.Int("concurrency.task.index", task.Index),
+			attribute.String("concurrency.task.kind", task.Kind.String()),
+		))
+		defer span.End()
+		err := next(ctx)
+		if err != nil {
+			var perr *concurrency.PanicError
+			if errors.As(err, &perr) {
+				span.AddEvent("panic", trace.WithAttributes(
+					attribute.String("panic.value", fmt.Sprint(perr.Value)),
+					attribute.String("panic.stack", string(perr.Stack)),
+				))
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	})
+}
+
+func spanName(task concurrency.TaskInfo) string {
+	if task.Name != "" {
+		return task.Name
+	}
+	return "concurrency." + task.Kind.String()
+}
@@ -0,0 +1,37 @@
+package otelconcurrency
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/alecthomas/concurrency"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tree, _ := concurrency.New(context.Background(), WithTracing(WithTracerProvider(provider)))
+	tree.Sub(func(ctx context.Context, sub *concurrency.Tree) error {
+		sub.GoNamed("child", func(ctx context.Context) error {
+			panic("boom")
+		})
+		return nil
+	})
+	assert.Error(t, tree.Wait())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	assert.Equal(t, 2, len(spans))
+	parent := spans["concurrency.sub"]
+	child := spans["child"]
+	assert.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, codes.Error, child.Status().Code)
+	assert.Equal(t, "panic", child.Events()[0].Name)
+}
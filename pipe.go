@@ -0,0 +1,35 @@
+package concurrency
+
+import (
+	"context"
+)
+
+// A Stage of a pipeline receives values from in and sends results to out.
+//
+// Stages should return once in is closed, and must not close out.
+type Stage[A, B any] func(ctx context.Context, in <-chan A, out chan<- B) error
+
+// Pipe runs stage in tree, returning a channel with the given buffer size that
+// receives its output and is closed when the stage returns.
+//
+// Pipes can be chained to build a pipeline, in which an error in any stage
+// cancels the whole tree.
+func Pipe[A, B any](tree *Tree, in <-chan A, buffer int, stage Stage[A, B]) <-chan B {
+	out := make(chan B, buffer)
+	tree.Go(func(ctx context.Context) error {
+		defer close(out)
+		return stage(ctx, in, out)
+	})
+	return out
+}
+
+// Compose returns a Stage that runs first and second concurrently, connected by
+// an unbuffered channel.
+func Compose[A, B, C any](first Stage[A, B], second Stage[B, C]) Stage[A, C] {
+	return func(ctx context.Context, in <-chan A, out chan<- C) error {
+		tree, _ := New(ctx)
+		mid := Pipe(tree, in, 0, first)
+		tree.Go(func(ctx context.Context) error { return second(ctx, mid, out) })
+		return tree.Wait()
+	}
+}
@@ -0,0 +1,116 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+)
+
+// ResultTree utilises a tree to collect the results of functions.
+type ResultTree[T any] struct {
+	tree    *Tree
+	results *results[T]
+}
+
+type results[T any] struct {
+	lock   sync.Mutex
+	values []T
+}
+
+// NewResultTree creates a new [ResultTree].
+func NewResultTree[T any](ctx context.Context, options ...Option) (*ResultTree[T], context.Context) {
+	tree, ctx := New(ctx, options...)
+	return &ResultTree[T]{tree: tree, results: &results[T]{}}, ctx
+}
+
+// Go runs fn in a goroutine as with [Tree.Go], recording its result.
+func (r *ResultTree[T]) Go(fn func(context.Context) (T, error)) {
+	r.results.lock.Lock()
+	index := len(r.results.values)
+	var zero T
+	r.results.values = append(r.results.values, zero)
+	r.results.lock.Unlock()
+	r.tree.Go(func(ctx context.Context) error {
+		value, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		r.results.lock.Lock()
+		r.results.values[index] = value
+		r.results.lock.Unlock()
+		return nil
+	})
+}
+
+// Sub calls fn with a new sub-tree, as with [Tree.Sub]. Results from the
+// sub-tree are collected by r.
+func (r *ResultTree[T]) Sub(fn func(context.Context, *ResultTree[T]) error, options ...Option) {
+	r.tree.Sub(func(ctx context.Context, sg *Tree) error {
+		return fn(ctx, &ResultTree[T]{tree: sg, results: r.results})
+	}, options...)
+}
+
+// Wait for the tree to finish, and return the results of all calls.
+//
+// Results are returned in the order in which Go was called. Failing functions
+// leave zero values in the result slice.
+func (r *ResultTree[T]) Wait() ([]T, error) {
+	err := r.tree.Wait()
+	r.results.lock.Lock()
+	defer r.results.lock.Unlock()
+	return append([]T(nil), r.results.values...), err
+}
+
+// Result is the outcome of a function submitted to a [ResultChannel].
+type Result[T any] struct {
+	Value T
+	// Err returned by the function, or a [PanicError] if it panicked.
+	Err  error
+	Task TaskInfo
+}
+
+// ResultChannel utilises a tree to send the [Result] of every function to a
+// channel, including failures, so that each function produces exactly one
+// Result.
+//
+// Errors returned by functions do not cancel the tree.
+type ResultChannel[T any] struct {
+	channel *Channel[Result[T]]
+}
+
+// NewResultChannel creates a new [ResultChannel] that sends to a channel with
+// the given buffer size, which is returned and closed when Wait returns.
+func NewResultChannel[T any](ctx context.Context, buffer int, options ...Option) (*ResultChannel[T], <-chan Result[T], context.Context) {
+	channel, results, ctx := NewChannel[Result[T]](ctx, buffer, options...)
+	return &ResultChannel[T]{channel: channel}, results, ctx
+}
+
+// Go runs fn in a goroutine as with [Tree.Go], sending its Result.
+func (r *ResultChannel[T]) Go(fn func(context.Context) (T, error)) {
+	r.GoNamed("", fn)
+}
+
+// GoNamed is like Go, but the task of the Result is named.
+func (r *ResultChannel[T]) GoNamed(name string, fn func(context.Context) (T, error)) {
+	tree := r.channel.tree
+	emit, done := r.channel.emitter()
+	tree.spawn(task{name: name, cost: 1, withInfo: func(ctx context.Context, info TaskInfo) error {
+		defer done()
+		result := Result[T]{Task: info}
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					result.Err = tree.recovered(p)
+				}
+			}()
+			result.Value, result.Err = fn(ctx)
+		}()
+		return emit(ctx, result)
+	}}, false)
+}
+
+// Wait for all functions to complete and close the channel.
+//
+// The error is only non-nil if the tree was cancelled.
+func (r *ResultChannel[T]) Wait() error {
+	return r.channel.Wait()
+}
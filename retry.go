@@ -0,0 +1,69 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// RetryPolicy controls how [Retry] retries a failing function.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the function will be called.
+	// If zero, attempts are unlimited.
+	MaxAttempts int
+	// MaxElapsed is the maximum time to spend retrying. No attempt will be
+	// started after it has elapsed. If zero, there is no limit.
+	MaxElapsed time.Duration
+	// Backoff is the delay before each retry. If nil, retries are immediate.
+	Backoff Backoff
+	// IsRetryable reports whether err should be retried. If nil, all errors
+	// other than those wrapped with [Permanent] are retried.
+	IsRetryable func(err error) bool
+	// OnRetry is called before each retry with the failed attempt, its error,
+	// and the delay before the next attempt.
+	OnRetry func(attempt int, err error, next time.Duration)
+}
+
+// Retry calls fn until it succeeds, the policy is exhausted, ctx is
+// cancelled, or it returns an error that is not retryable, returning the last
+// error.
+//
+// Errors wrapped with [Permanent] are returned unwrapped.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(context.Context) error) error {
+	_, err := RetryValue(ctx, policy, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// RetryValue calls fn until it succeeds, the policy is exhausted, or ctx is
+// cancelled, returning its value or the last error.
+func RetryValue[T any](ctx context.Context, policy RetryPolicy, fn func(context.Context) (T, error)) (T, error) {
+	start := time.Now()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		value, err := fn(ctx)
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			return value, permanent.Err
+		}
+		if err == nil || ctx.Err() != nil || (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) {
+			return value, err
+		}
+		if policy.IsRetryable != nil && !policy.IsRetryable(err) {
+			return value, err
+		}
+		if policy.Backoff != nil {
+			delay = policy.Backoff(attempt, delay)
+		}
+		if policy.MaxElapsed > 0 && time.Since(start)+delay >= policy.MaxElapsed {
+			return value, err
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, delay)
+		}
+		if !sleep(ctx.Done(), delay) {
+			return value, err
+		}
+	}
+}
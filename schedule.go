@@ -0,0 +1,186 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OverlapPolicy controls what a fixed-rate schedule does when a run takes
+// longer than its period and overlaps the next slot.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip skips slots that were missed and runs at the next slot in the
+	// future, preserving the original cadence.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapDelay runs immediately and anchors subsequent slots to that run.
+	OverlapDelay
+	// OverlapBurst runs once immediately for each missed slot until the
+	// schedule has caught up.
+	OverlapBurst
+)
+
+// ScheduleOption configures [Schedule].
+type ScheduleOption func(*scheduleOptions)
+
+type scheduleOptions struct {
+	fixedRate    bool
+	overlap      OverlapPolicy
+	initialDelay time.Duration
+	maxRuns      int
+}
+
+// InitialDelay delays the first run by d rather than running immediately.
+func InitialDelay(d time.Duration) ScheduleOption {
+	return func(o *scheduleOptions) {
+		o.initialDelay = d
+	}
+}
+
+// MaxRuns stops the schedule after fn has been called n times.
+func MaxRuns(n int) ScheduleOption {
+	return func(o *scheduleOptions) {
+		o.maxRuns = n
+	}
+}
+
+// FixedRate schedules runs at a steady cadence, measuring the returned delay
+// from the start of each run rather than its end.
+//
+// policy controls what happens when a run overlaps its next slot.
+func FixedRate(policy OverlapPolicy) ScheduleOption {
+	return func(o *scheduleOptions) {
+		o.fixedRate = true
+		o.overlap = policy
+	}
+}
+
+// Scheduled is a handle to a function scheduled with [Schedule].
+type Scheduled struct {
+	stop     chan struct{}
+	stopOnce sync.Once
+	trigger  chan struct{}
+
+	lock    sync.Mutex
+	lastRun time.Time
+	lastErr error
+}
+
+// Stop the schedule. A run in progress will complete, but no further runs will
+// start.
+func (s *Scheduled) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// TriggerNow runs the function immediately, rescheduling subsequent runs
+// relative to it. It has no effect if a trigger is already pending.
+func (s *Scheduled) TriggerNow() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// LastRun returns the start time and error of the most recently completed
+// run, or the zero time if there has been none.
+func (s *Scheduled) LastRun() (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.lastRun, s.lastErr
+}
+
+// Schedule calls fn every time interval until it returns an error, the
+// schedule is stopped, or the context is cancelled.
+//
+// By default the interval returned by fn is the delay after it completes
+// before it is called again. See [FixedRate] for a steady cadence.
+func Schedule(tree *Tree, fn func(context.Context) (time.Duration, error), options ...ScheduleOption) *Scheduled {
+	opts := newScheduleOptions(options)
+	s := newScheduled()
+	s.run(tree, opts, time.Now().Add(opts.initialDelay), func(ctx context.Context, slot time.Time) (time.Time, bool, error) {
+		interval, err := fn(ctx)
+		if !opts.fixedRate {
+			return time.Now().Add(interval), true, err
+		}
+		return opts.nextSlot(slot, interval, time.Now()), true, err
+	})
+	return s
+}
+
+func newScheduleOptions(options []ScheduleOption) scheduleOptions {
+	opts := scheduleOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	return opts
+}
+
+func newScheduled() *Scheduled {
+	return &Scheduled{
+		stop:    make(chan struct{}),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// run calls fn in tree at slot, then repeatedly at the time it returns until
+// it returns false or an error, or the schedule is stopped.
+//
+// fn is passed the time its run was scheduled for.
+func (s *Scheduled) run(tree *Tree, opts scheduleOptions, slot time.Time, fn func(ctx context.Context, slot time.Time) (time.Time, bool, error)) {
+	tree.Go(func(ctx context.Context) error {
+		for runs := 0; opts.maxRuns <= 0 || runs < opts.maxRuns; runs++ {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			timer := time.NewTimer(time.Until(slot))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+
+			case <-s.stop:
+				timer.Stop()
+				return nil
+
+			case <-s.trigger:
+				timer.Stop()
+				slot = time.Now()
+
+			case <-timer.C:
+			}
+			start := time.Now()
+			next, more, err := fn(ctx, slot)
+			s.lock.Lock()
+			s.lastRun, s.lastErr = start, err
+			s.lock.Unlock()
+			if err != nil || !more {
+				return err
+			}
+			slot = next
+		}
+		return nil
+	})
+}
+
+// nextSlot returns the time of the run following the slot at previous.
+func (o scheduleOptions) nextSlot(previous time.Time, interval time.Duration, now time.Time) time.Time {
+	next := previous.Add(interval)
+	if !next.Before(now) {
+		return next
+	}
+	switch o.overlap {
+	case OverlapSkip:
+		if interval <= 0 {
+			return now
+		}
+		missed := now.Sub(next)/interval + 1
+		return next.Add(missed * interval)
+	case OverlapDelay:
+		return now
+	default:
+		return next
+	}
+}
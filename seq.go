@@ -0,0 +1,94 @@
+//go:build go1.23
+
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"runtime"
+)
+
+// Seq waits for the Channel as with Wait, yielding values as they are
+// produced, followed by the error from Wait if it is not nil.
+//
+// If the consumer stops iterating the tree is cancelled. Seq should be called
+// once all functions have been submitted, and only on a Channel created by
+// [NewChannel].
+func (v *Channel[T]) Seq() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		if !v.owned {
+			yield(zero, errors.New("concurrency: Seq requires a Channel created by NewChannel"))
+			return
+		}
+		errs := make(chan error, 1)
+		go func() { errs <- v.Wait() }()
+		for value := range v.recv {
+			if !yield(value, nil) {
+				v.tree.cancel(context.Canceled)
+				// Drain remaining values so that producers can finish.
+				for range v.recv {
+				}
+				<-errs
+				return
+			}
+		}
+		if err := <-errs; err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// MapSeq returns a sequence of the results of calling fn for each value in in,
+// with bounded concurrency.
+//
+// The number of concurrent calls to fn is the concurrency limit set by
+// [WithConcurrencyLimit], or GOMAXPROCS if there is no limit. Results are not
+// ordered unless [Ordered] is passed. Errors, including those from in, are
+// yielded once all calls have completed, and if the consumer stops iterating
+// the remaining calls are cancelled.
+func MapSeq[U, T any](ctx context.Context, in iter.Seq[U], fn func(context.Context, U) (T, error), options ...Option) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		src, producer := Generate(ctx, func(ctx context.Context, emit func(U) error) error {
+			for value := range in {
+				if err := emit(value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		out, _, _ := NewChannel[T](ctx, 0, options...)
+		workers := out.tree.ConcurrencyLimit()
+		if workers == 0 {
+			workers = runtime.GOMAXPROCS(0)
+		}
+		consume(out, src, workers, func(ctx context.Context, value U, emit func(context.Context, T) error) error {
+			result, err := fn(ctx, value)
+			if err != nil {
+				return err
+			}
+			return emit(ctx, result)
+		})
+		failed := false
+		for value, err := range out.Seq() {
+			if err != nil {
+				failed = true
+			}
+			if !yield(value, err) {
+				cancel()
+				_ = producer.Wait()
+				return
+			}
+		}
+		// Once out completes successfully in has been exhausted, so any error
+		// from the producer is not the result of cancelling it here.
+		cancel()
+		if err := producer.Wait(); err != nil && !failed {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//go:build go1.23
+
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/alecthomas/assert/v2"
+)
+
+func TestChannelSeq(t *testing.T) {
+	t.Parallel()
+	wg, _, _ := NewChannel[int](context.Background(), 0)
+	for i := 1; i <= 3; i++ {
+		wg.Go(func(ctx context.Context) (int, error) { return i, nil })
+	}
+	actual := []int{}
+	for value, err := range wg.Seq() {
+		assert.NoError(t, err)
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{1, 2, 3}, actual)
+
+	wg, _, _ = NewChannel[int](context.Background(), 0)
+	wg.GoMany(func(ctx context.Context, emit func(int) error) error {
+		for i := 0; ; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+	})
+	for value := range wg.Seq() {
+		if value == 2 {
+			break
+		}
+	}
+
+	wg, _, _ = NewChannel[int](context.Background(), 0)
+	wg.Go(func(ctx context.Context) (int, error) { return 0, errors.New("failed") })
+	for _, err := range wg.Seq() {
+		assert.EqualError(t, err, "failed")
+	}
+}
+
+func TestMapSeq(t *testing.T) {
+	t.Parallel()
+	numbers := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	actual := []int{}
+	for value, err := range MapSeq(context.Background(), numbers, func(ctx context.Context, value int) (int, error) {
+		return value * 2, nil
+	}, Ordered(), WithConcurrencyLimit(4)) {
+		assert.NoError(t, err)
+		if len(actual) == 5 {
+			break
+		}
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{0, 2, 4, 6, 8}, actual)
+
+	for _, err := range MapSeq(context.Background(), slices.Values([]int{1}), func(ctx context.Context, value int) (int, error) {
+		return 0, errors.New("failed")
+	}) {
+		assert.EqualError(t, err, "failed")
+	}
+}
+
+func TestMapSeqProducerError(t *testing.T) {
+	t.Parallel()
+	panicky := func(yield func(int) bool) {
+		if !yield(1) {
+			return
+		}
+		panic("boom")
+	}
+	var values []int
+	var errs []error
+	for value, err := range MapSeq(context.Background(), panicky, func(ctx context.Context, value int) (int, error) { return value, nil }) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values = append(values, value)
+	}
+	assert.Equal(t, []int{1}, values)
+	assert.Equal(t, 1, len(errs))
+	var perr *PanicError
+	assert.True(t, errors.As(errs[0], &perr))
+}
@@ -0,0 +1,124 @@
+package concurrency
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// TaskState is the state of a function in a [Tree].
+type TaskState int
+
+const (
+	// TaskQueued functions are waiting to start.
+	TaskQueued TaskState = iota
+	// TaskRunning functions have started but not completed.
+	TaskRunning
+)
+
+func (s TaskState) String() string {
+	switch s {
+	case TaskQueued:
+		return "queued"
+	case TaskRunning:
+		return "running"
+	default:
+		return "unknown"
+	}
+}
+
+// TaskSnapshot is the state of a single function in a [TreeSnapshot].
+type TaskSnapshot struct {
+	Task  TaskInfo
+	State TaskState
+	// Duration the function has been in its current state.
+	Duration time.Duration
+}
+
+// TreeSnapshot is the state of a [Tree] and its sub-trees at a point in time.
+type TreeSnapshot struct {
+	// Name of the tree set by [WithName], if any.
+	Name  string
+	Stats Stats
+	// Tasks that have not yet completed, in the order they were submitted.
+	Tasks []TaskSnapshot
+	// Children are the sub-trees that are currently running.
+	Children []TreeSnapshot
+}
+
+// Snapshot returns the current state of the tree and its sub-trees, eg. for
+// display in a debug endpoint.
+func (g *Tree) Snapshot() TreeSnapshot {
+	now := time.Now()
+	snapshot := TreeSnapshot{Name: g.name, Stats: g.Stats()}
+	g.liveLock.Lock()
+	for _, live := range g.live {
+		snapshot.Tasks = append(snapshot.Tasks, TaskSnapshot{Task: live.info, State: live.state, Duration: now.Sub(live.since)})
+	}
+	children := make([]*Tree, 0, len(g.children))
+	for child := range g.children {
+		children = append(children, child)
+	}
+	g.liveLock.Unlock()
+	sort.Slice(snapshot.Tasks, func(i, j int) bool { return snapshot.Tasks[i].Task.Index < snapshot.Tasks[j].Task.Index })
+	sort.Slice(children, func(i, j int) bool { return children[i].created.Before(children[j].created) })
+	for _, child := range children {
+		snapshot.Children = append(snapshot.Children, child.Snapshot())
+	}
+	return snapshot
+}
+
+type liveTask struct {
+	info   TaskInfo
+	state  TaskState
+	since  time.Time
+	ctx    context.Context //nolint: containedctx
+	cancel context.CancelCauseFunc
+}
+
+// track assigns t the next index in the tree and records it as queued.
+func (g *Tree) track(t *task, kind TaskKind) {
+	t.index = int(g.tasks.Add(1) - 1)
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	live := &liveTask{info: t.info(g, kind), state: TaskQueued, since: time.Now(), ctx: g.ctx}
+	if t.tag != "" {
+		live.ctx, live.cancel = context.WithCancelCause(g.ctx)
+	}
+	g.live[t.index] = live
+}
+
+// started marks the task as running and returns the context it should run
+// with.
+func (g *Tree) started(index int) context.Context {
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	live, ok := g.live[index]
+	if !ok {
+		return g.ctx
+	}
+	live.state = TaskRunning
+	live.since = time.Now()
+	return live.ctx
+}
+
+func (g *Tree) untrack(index int) {
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	if live, ok := g.live[index]; ok && live.cancel != nil {
+		live.cancel(nil)
+	}
+	delete(g.live, index)
+}
+
+func (g *Tree) addChild(child *Tree) {
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	g.children[child] = struct{}{}
+}
+
+func (g *Tree) removeChild(child *Tree) {
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	delete(g.children, child)
+}
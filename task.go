@@ -0,0 +1,77 @@
+package concurrency
+
+import (
+	"context"
+)
+
+// TaskKind is the method used to submit a function to a [Tree].
+type TaskKind int
+
+const (
+	// TaskGo is a function started with Go or one of its variants.
+	TaskGo TaskKind = iota
+	// TaskSub is a function started with Sub.
+	TaskSub
+	// TaskLink is a Waiter linked with Link.
+	TaskLink
+)
+
+func (k TaskKind) String() string {
+	switch k {
+	case TaskGo:
+		return "go"
+	case TaskSub:
+		return "sub"
+	case TaskLink:
+		return "link"
+	default:
+		return "unknown"
+	}
+}
+
+// TaskInfo describes a function submitted to a [Tree].
+type TaskInfo struct {
+	// Tree is the name of the tree set by [WithName], if any.
+	Tree string
+	// Name of the task passed to [Tree.GoNamed], if any.
+	Name string
+	// Index of the task in the order it was submitted to its tree.
+	Index int
+	Kind  TaskKind
+	// Tag passed to [Tree.GoTagged] or [Tree.SubTagged], if any.
+	Tag string
+}
+
+// An Interceptor wraps the execution of every function in a [Tree].
+//
+// The Interceptor must call next to run the function, and may replace the
+// context passed to it. Panics in the function are passed to the Interceptor
+// as a [PanicError].
+type Interceptor func(ctx context.Context, task TaskInfo, next func(context.Context) error) error
+
+// WithInterceptor adds an [Interceptor] to the tree.
+//
+// Interceptors are called in the order they are added, with the first being
+// outermost. The context passed to a sub-tree created by [Tree.Sub] is derived
+// from the context passed to next.
+func WithInterceptor(interceptor Interceptor) Option {
+	return func(o *Tree) {
+		o.interceptors = append(o.interceptors, interceptor)
+	}
+}
+
+// task is a function submitted to the tree.
+type task struct {
+	name     string
+	tag      string
+	index    int
+	cost     int64
+	priority int
+	fn       func(context.Context) error
+	withInfo func(context.Context, TaskInfo) error // Replaces fn if set.
+	unqueued bool                                  // Bypasses the queue limit.
+}
+
+func (t task) info(g *Tree, kind TaskKind) TaskInfo {
+	return TaskInfo{Tree: g.name, Name: t.name, Index: t.index, Kind: kind, Tag: t.tag}
+}
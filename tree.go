@@ -4,13 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	"golang.org/x/sync/semaphore"
 )
 
-func NoJitter() time.Duration { return 0 }
+// ErrWaitTimeout is returned by [Tree.WaitContext] and [Tree.WaitTimeout] if
+// the tree has not completed in time.
+var ErrWaitTimeout = errors.New("concurrency: timed out waiting for tree")
 
 // A Waiter is a type that can wait for completion.
 type Waiter interface {
@@ -20,86 +26,794 @@ type Waiter interface {
 // A Tree manages calling a set of functions returning errors, with optional
 // concurrency limits. trees can be arranged in a tree.
 //
-// Panics in functions are recovered and cause the tree to be cancelled.
+// Panics in functions are recovered and cause the tree to be cancelled with a
+// [PanicError].
 type Tree struct {
-	ctx              context.Context //nolint: containedctx
-	cancel           context.CancelCauseFunc
-	wg               sync.WaitGroup
-	options          []Option
-	concurrencyLimit *semaphore.Weighted
-	jitter           func() time.Duration
+	base           context.Context //nolint: containedctx
+	ctx            context.Context //nolint: containedctx
+	cancel         context.CancelCauseFunc
+	release        context.CancelCauseFunc // Cancels ctx without reporting it.
+	wg             sync.WaitGroup
+	options        []Option
+	noInherit      bool // Set by options that sub-trees should not inherit.
+	inheritNone    bool
+	name           string
+	isolated       bool
+	limiter        *limiter
+	shareLimit     bool
+	queue          chan struct{}
+	rateLimit      *rateLimiter
+	taskTimeout    time.Duration
+	restart        *RestartPolicy
+	deadline       time.Time
+	timeout        time.Duration
+	stopDeadline   func() // Non-nil if the tree has a deadline.
+	signals        []os.Signal
+	tasks          atomic.Int64
+	draining       atomic.Bool
+	fifo           *sequencer
+	onTaskStart    func(ctx context.Context, name string)
+	onTaskDone     func(ctx context.Context, name string, err error, duration time.Duration)
+	interceptors   []Interceptor
+	onGoAfterWait  func(err *GoAfterWaitError)
+	onDiscard      func(err *DiscardedError)
+	channel        channelOptions // Options of a Channel using the tree.
+	waited         atomic.Bool
+	hungThreshold  time.Duration
+	hungStacks     bool
+	onHung         func(running []RunningTask)
+	logger         *slog.Logger
+	logLevels      LogLevels
+	metrics        Metrics
+	cancelOnce     sync.Once
+	jitter         func(ctx context.Context, task TaskInfo) time.Duration
+	collectErrors  bool
+	noRecover      bool
+	errorPaths     bool
+	errorThreshold int
+
+	stats treeStats
+
+	errorsLock     sync.Mutex
+	errors         []error
+	isolatedErrors []error
+
+	completionLock sync.Mutex
+	completion     chan struct{} // Closed and replaced whenever a function completes.
+	completions    int
+	firstErr       error
+
+	parent         *Tree
+	created        time.Time
+	goroutinesLock sync.Mutex
+	goroutines     map[uint64]*RunningTask // Goroutines running functions in this tree or its sub-trees, if onHung is set.
+
+	liveLock sync.Mutex
+	live     map[int]*liveTask // Functions in this tree that have not completed.
+	children map[*Tree]struct{}
+
+	deferredLock sync.Mutex
+	deferred     []func(err error)
+	deferredDone chan struct{} // Closed once the last deferred functions to run have returned.
+
+	linksLock sync.Mutex
+	links     []func() bool // Stops contexts linked by LinkContext.
+
+	pauseLock sync.Mutex
+	resumed   chan struct{} // Non-nil while paused.
+}
+
+// Stats is a snapshot of the state of functions in a [Tree].
+type Stats struct {
+	// Running functions.
+	Running int64
+	// Queued functions waiting to start.
+	Queued int64
+	// Completed functions that returned successfully.
+	Completed int64
+	// Failed functions that returned an error or panicked.
+	Failed int64
+}
+
+type treeStats struct {
+	running   atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Int64
+	failed    atomic.Int64
 }
 
 type Option func(*Tree)
 
+// WithName sets the name of the tree, used when logging.
+//
+// Unlike other options, the name is not inherited by sub-trees.
+func WithName(name string) Option {
+	return func(o *Tree) {
+		o.name = name
+		o.noInherit = true
+	}
+}
+
+// NoInherit applies option to the tree, but not to its sub-trees.
+//
+// eg. New(ctx, WithConcurrencyLimit(4), NoInherit(WithJitter(jitter)))
+func NoInherit(option Option) Option {
+	return func(o *Tree) {
+		option(o)
+		o.noInherit = true
+	}
+}
+
+// WithNoInherit stops sub-trees from inheriting any of the tree's options, so
+// that they start with only the options passed to [Tree.Sub].
+func WithNoInherit() Option {
+	return func(o *Tree) {
+		o.inheritNone = true
+		o.noInherit = true
+	}
+}
+
 // WithJitter sets the jitter function used to delay the start of each goroutine.
 func WithJitter(fn func() time.Duration) Option {
+	return func(o *Tree) {
+		o.jitter = func(context.Context, TaskInfo) time.Duration { return fn() }
+	}
+}
+
+// WithJitterFunc sets a jitter function that is passed the context and
+// details of each function, allowing eg. start times to be staggered by index.
+func WithJitterFunc(fn func(ctx context.Context, task TaskInfo) time.Duration) Option {
 	return func(o *Tree) {
 		o.jitter = fn
 	}
 }
 
+// WithCollectErrors records the error of every failing function in the tree.
+//
+// Wait will then return all recorded errors combined with [errors.Join], rather
+// than just the first.
+func WithCollectErrors() Option {
+	return func(o *Tree) {
+		o.collectErrors = true
+	}
+}
+
+// WithContinueOnError stops failing functions from cancelling the tree.
+//
+// Errors are recorded as with [WithCollectErrors] and returned by Wait once
+// all functions have completed.
+func WithContinueOnError() Option {
+	return func(o *Tree) {
+		o.collectErrors = true
+		o.errorThreshold = math.MaxInt
+	}
+}
+
+// WithErrorThreshold allows up to n functions to fail before the tree is
+// cancelled.
+//
+// Errors are recorded as with [WithCollectErrors] and returned by Wait.
+func WithErrorThreshold(n int) Option {
+	return func(o *Tree) {
+		o.collectErrors = true
+		o.errorThreshold = n
+	}
+}
+
+// Isolated is an option for [Tree.Sub] that stops errors in the sub-tree from
+// cancelling its parent.
+//
+// The error is instead recorded by the parent and can be retrieved with
+// [Tree.IsolatedErrors]. Isolated is not inherited by further sub-trees.
+func Isolated() Option {
+	return func(o *Tree) {
+		o.isolated = true
+		o.noInherit = true
+	}
+}
+
+// WithErrorPaths wraps errors returned by functions in a [TaskError] recording
+// the names of the trees and task that produced them, eg.
+// "root/ingest/worker-3: connection refused".
+func WithErrorPaths() Option {
+	return func(o *Tree) {
+		o.errorPaths = true
+	}
+}
+
+// WithoutRecover disables recovery of panics in functions, so that they crash
+// the process rather than cancelling the tree with a [PanicError].
+func WithoutRecover() Option {
+	return func(o *Tree) {
+		o.noRecover = true
+	}
+}
+
+// WithSignals cancels the tree with a [SignalError] when one of signals is
+// received, defaulting to SIGINT and SIGTERM.
+//
+// WithSignals is not inherited by sub-trees.
+func WithSignals(signals ...os.Signal) Option {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	return func(o *Tree) {
+		o.signals = signals
+		o.noInherit = true
+	}
+}
+
 // WithConcurrencyLimit sets the maximum number of goroutines that will be
 // executed concurrently by the tree before blocking.
 //
 // A value of 0 disables the limit.
 func WithConcurrencyLimit(n int) Option {
+	return func(o *Tree) {
+		o.limiter = newLimiter(int64(n))
+	}
+}
+
+// WithSharedConcurrencyLimit causes sub-trees to share the concurrency limit of
+// the tree, rather than each having their own copy, so that the limit applies
+// to all functions in the tree and its sub-trees.
+//
+// Concurrency limits set on sub-trees are ignored. A function that waits on a
+// sub-tree while holding a slot of a shared limit may deadlock.
+func WithSharedConcurrencyLimit() Option {
+	return func(o *Tree) {
+		o.shareLimit = true
+	}
+}
+
+type treeKey struct{}
+
+// WithRateLimit limits the rate at which functions are started by the tree to
+// rps per second, with bursts of up to burst functions.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(o *Tree) {
+		o.rateLimit = newRateLimiter(rps, burst)
+	}
+}
+
+// WithTaskTimeout sets a deadline of timeout on the context passed to each
+// function started with Go.
+//
+// If a function fails after its deadline is exceeded, the error is replaced
+// with a [TaskTimeoutError].
+func WithTaskTimeout(timeout time.Duration) Option {
+	return func(o *Tree) {
+		o.taskTimeout = timeout
+	}
+}
+
+// RestartPolicy controls how failing functions are restarted by
+// [WithRestart].
+type RestartPolicy struct {
+	// MaxRestarts is the number of times a function will be restarted before
+	// its error is returned.
+	MaxRestarts int
+	// Backoff is the delay before each restart. If nil, restarts are immediate.
+	Backoff Backoff
+}
+
+// WithRestart restarts functions started with Go that return an error or
+// panic, according to policy.
+//
+// Functions are not restarted once the tree is cancelled.
+func WithRestart(policy RestartPolicy) Option {
+	return func(o *Tree) {
+		o.restart = &policy
+	}
+}
+
+// WithDeadline cancels the tree with a [TreeTimeoutError] at deadline.
+//
+// The deadline is visible to functions through their context's Deadline.
+func WithDeadline(deadline time.Time) Option {
+	return func(o *Tree) {
+		o.deadline = deadline
+		o.timeout = 0
+	}
+}
+
+// WithTimeout cancels the tree with a [TreeTimeoutError] after timeout.
+//
+// The timeout starts when the tree is created or [Tree.Reset], so sub-trees
+// that inherit it will time out relative to their own creation.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Tree) {
+		o.deadline = time.Time{}
+		o.timeout = timeout
+	}
+}
+
+// WithFIFO guarantees that functions are started in the order in which they
+// were submitted to the tree, rather than in whichever order they happen to
+// acquire the concurrency limit.
+//
+// Priorities set with [Tree.GoPriority] are ignored, and a function that is
+// delayed by jitter will delay all functions submitted after it.
+func WithFIFO() Option {
+	return func(o *Tree) {
+		o.fifo = newSequencer()
+	}
+}
+
+// WithOnTaskStart sets a function that is called before each function started
+// with Go, Sub or Link runs.
+//
+// name is the name passed to [Tree.GoNamed], or empty.
+func WithOnTaskStart(fn func(ctx context.Context, name string)) Option {
+	return func(o *Tree) {
+		o.onTaskStart = fn
+	}
+}
+
+// WithOnTaskDone sets a function that is called after each function started
+// with Go, Sub or Link completes, with its error and how long it ran for.
+//
+// name is the name passed to [Tree.GoNamed], or empty.
+func WithOnTaskDone(fn func(ctx context.Context, name string, err error, duration time.Duration)) Option {
+	return func(o *Tree) {
+		o.onTaskDone = fn
+	}
+}
+
+// WithQueueLimit sets the maximum number of goroutines that may be waiting to
+// start under the concurrency limit.
+//
+// Once the limit is reached, Go will block until a queued goroutine starts or
+// the tree is cancelled. A value of 0 disables the limit.
+func WithQueueLimit(n int) Option {
 	return func(o *Tree) {
 		if n == 0 {
-			o.concurrencyLimit = nil
+			o.queue = nil
 		} else {
-			o.concurrencyLimit = semaphore.NewWeighted(int64(n))
+			o.queue = make(chan struct{}, n)
 		}
 	}
 }
 
 // New creates a new [Tree].
 func New(ctx context.Context, options ...Option) (*Tree, context.Context) {
-	ctx, cancel := context.WithCancelCause(ctx)
-	g := &Tree{ctx: ctx, cancel: cancel, options: options, jitter: NoJitter}
+	g := &Tree{
+		base:       ctx,
+		limiter:    newLimiter(0),
+		logLevels:  DefaultLogLevels,
+		completion: make(chan struct{}),
+		goroutines: map[uint64]*RunningTask{},
+		live:       map[int]*liveTask{},
+		children:   map[*Tree]struct{}{},
+	}
+	g.parent, _ = TreeFromContext(ctx)
+	g.created = time.Now()
 	for _, option := range options {
+		g.noInherit = false
 		option(g)
+		if !g.noInherit {
+			g.options = append(g.options, option)
+		}
+	}
+	if g.inheritNone {
+		g.options = nil
+	}
+	if g.shareLimit && g.parent != nil && g.parent.shareLimit {
+		g.limiter = g.parent.limiter
+	}
+	g.arm()
+	return g, g.ctx
+}
+
+// arm creates the tree's context and starts its deadline timer, if any.
+func (g *Tree) arm() {
+	ctx, cancel := context.WithCancelCause(g.base)
+	g.release = cancel
+	g.cancel = func(cause error) {
+		cancel(cause)
+		g.cancelled()
+	}
+	deadline := g.deadline
+	if g.timeout > 0 {
+		deadline = time.Now().Add(g.timeout)
+	}
+	if !deadline.IsZero() {
+		// A context deadline rather than a timer so that functions can observe it.
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadlineCause(ctx, deadline, &TreeTimeoutError{Deadline: deadline})
+		deadlineCtx := ctx
+		stopReport := context.AfterFunc(deadlineCtx, func() {
+			var timeout *TreeTimeoutError
+			if errors.As(context.Cause(deadlineCtx), &timeout) {
+				g.cancelled()
+			}
+		})
+		g.stopDeadline = func() {
+			stopReport()
+			cancelDeadline()
+		}
+	}
+	g.ctx = context.WithValue(ctx, treeKey{}, g)
+	if len(g.signals) > 0 {
+		g.watchSignals(ctx)
 	}
-	return g, ctx
+}
+
+// watchSignals cancels the tree when a signal is received, until ctx is done
+// or Wait returns.
+func (g *Tree) watchSignals(ctx context.Context) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, g.signals...)
+	stopped := make(chan struct{})
+	var once sync.Once
+	g.linksLock.Lock()
+	g.links = append(g.links, func() bool {
+		once.Do(func() { close(stopped) })
+		return true
+	})
+	g.linksLock.Unlock()
+	go func() {
+		defer signal.Stop(received)
+		select {
+		case sig := <-received:
+			g.cancel(&SignalError{Signal: sig})
+		case <-ctx.Done():
+		case <-stopped:
+		}
+	}()
+}
+
+// Reset re-arms the tree after Wait has returned so that it can be reused,
+// returning the tree's new context.
+//
+// Collected errors and statistics are cleared. Reset must not be called while
+// functions are running in the tree.
+func (g *Tree) Reset() context.Context {
+	if g.stopDeadline != nil {
+		g.stopDeadline()
+		g.stopDeadline = nil
+	}
+	g.release(context.Canceled)
+	g.cancelOnce = sync.Once{}
+	g.draining.Store(false)
+	g.waited.Store(false)
+	g.tasks.Store(0)
+	g.stats.completed.Store(0)
+	g.stats.failed.Store(0)
+	g.errorsLock.Lock()
+	g.errors, g.isolatedErrors = nil, nil
+	g.errorsLock.Unlock()
+	g.completionLock.Lock()
+	g.completions, g.firstErr = 0, nil
+	g.completionLock.Unlock()
+	g.arm()
+	return g.ctx
+}
+
+// TreeFromContext returns the [Tree] that owns ctx.
+//
+// ctx must be the context returned by [New], or a context derived from one of
+// the contexts passed to a function by the tree.
+func TreeFromContext(ctx context.Context) (*Tree, bool) {
+	g, ok := ctx.Value(treeKey{}).(*Tree)
+	return g, ok
 }
 
 // Go runs fn in a goroutine, and cancels the tree if any function returns an
 // error.
 //
-// The context passed to fn is a child of the context passed to New. A new
-// sub-tree can be created from this context by calling treeFromContext.
+// The context passed to fn is a child of the context passed to New. The tree
+// can be retrieved from this context by calling [TreeFromContext].
 func (g *Tree) Go(fn func(context.Context) error) {
+	g.spawn(task{cost: 1, fn: fn}, false)
+}
+
+// goUnqueued is like Go, but bypasses the queue limit. It is used by tasks that
+// submit further work, which would otherwise deadlock on a full queue while
+// holding a slot of the concurrency limit.
+func (g *Tree) goUnqueued(fn func(context.Context) error) {
+	g.spawn(task{cost: 1, fn: fn, unqueued: true}, false)
+}
+
+// GoNoCtx is like Go, for functions that do not need the context.
+func (g *Tree) GoNoCtx(fn func() error) {
+	g.Go(func(context.Context) error { return fn() })
+}
+
+// GoNamed is like Go, but errors and panics from fn are annotated with name.
+func (g *Tree) GoNamed(name string, fn func(context.Context) error) {
+	g.spawn(task{name: name, cost: 1, fn: fn}, false)
+}
+
+// GoTagged is like Go, but fn can be cancelled along with other functions with
+// the same tag by [Tree.CancelTag].
+func (g *Tree) GoTagged(tag string, fn func(context.Context) error) {
+	g.spawn(task{tag: tag, cost: 1, fn: fn}, false)
+}
+
+// GoWeighted is like Go, but fn consumes cost slots of the concurrency limit
+// rather than one.
+//
+// A function whose cost exceeds the concurrency limit will run once nothing
+// else is running in the tree.
+func (g *Tree) GoWeighted(cost int64, fn func(context.Context) error) {
+	g.spawn(task{cost: cost, fn: fn}, false)
+}
+
+// GoPriority is like Go, but when waiting for the concurrency limit functions
+// with a higher priority are started before those with a lower priority.
+//
+// Functions started with Go have a priority of 0.
+func (g *Tree) GoPriority(priority int, fn func(context.Context) error) {
+	g.spawn(task{cost: 1, priority: priority, fn: fn}, false)
+}
+
+// GoN runs n copies of fn, each in its own goroutine as with Go.
+//
+// Each copy is passed its worker index, from 0 to n-1.
+func (g *Tree) GoN(n int, fn func(ctx context.Context, worker int) error) {
+	for i := 0; i < n; i++ {
+		i := i
+		g.Go(func(ctx context.Context) error { return fn(ctx, i) })
+	}
+}
+
+// TryGo runs fn in a goroutine only if a slot is immediately available under
+// the concurrency limit, returning false if it is not.
+//
+// If the tree has no concurrency limit TryGo always succeeds.
+func (g *Tree) TryGo(fn func(context.Context) error) bool {
+	if g.draining.Load() || g.paused() || !g.limiter.TryAcquire(1) {
+		return false
+	}
+	if g.rateLimit != nil && !g.rateLimit.Allow() {
+		g.limiter.Release(1)
+		return false
+	}
+	g.spawn(task{cost: 1, fn: fn}, true)
+	return true
+}
+
+// SetConcurrencyLimit changes the maximum number of goroutines that will be
+// executed concurrently by the tree.
+//
+// Running goroutines are unaffected, so lowering the limit takes effect as
+// they complete. A value of 0 disables the limit. Existing sub-trees are not
+// affected.
+func (g *Tree) SetConcurrencyLimit(n int) {
+	g.limiter.Resize(int64(n))
+}
+
+// ConcurrencyLimit returns the current concurrency limit of the tree, or 0 if
+// there is no limit.
+func (g *Tree) ConcurrencyLimit() int {
+	return int(g.limiter.Size())
+}
+
+// Pause stops the tree from starting any further functions until Resume is
+// called.
+//
+// Functions that are already running are unaffected, and the tree is not
+// cancelled. Functions submitted with Go, Sub, Link and their variants while
+// paused are queued.
+func (g *Tree) Pause() {
+	g.pauseLock.Lock()
+	defer g.pauseLock.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume starting functions after a call to Pause.
+func (g *Tree) Resume() {
+	g.pauseLock.Lock()
+	defer g.pauseLock.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *Tree) paused() bool {
+	g.pauseLock.Lock()
+	defer g.pauseLock.Unlock()
+	return g.resumed != nil
+}
+
+// waitResumed blocks while the tree is paused.
+func (g *Tree) waitResumed() error {
+	for {
+		g.pauseLock.Lock()
+		resumed := g.resumed
+		g.pauseLock.Unlock()
+		if resumed == nil {
+			return nil
+		}
+		select {
+		case <-resumed:
+		case <-g.ctx.Done():
+			return g.ctx.Err()
+		}
+	}
+}
+
+// spawn runs t in a goroutine. If acquired is true the caller has already
+// acquired a slot from the concurrency limit.
+func (g *Tree) spawn(t task, acquired bool) {
+	if g.draining.Load() {
+		if acquired {
+			g.limiter.Release(t.cost)
+		}
+		g.discarded(TaskGo)
+		return
+	}
+	g.checkSubmit()
+	g.track(&t, TaskGo)
+	if t.withInfo != nil {
+		info, withInfo := t.info(g, TaskGo), t.withInfo
+		t.fn = func(ctx context.Context) error { return withInfo(ctx, info) }
+	}
+	queued := false
+	if g.queue != nil && !acquired && !t.unqueued {
+		select {
+		case g.queue <- struct{}{}:
+			queued = true
+		case <-g.ctx.Done():
+		}
+	}
+	var ticket uint64
+	fifo := g.fifo != nil && !acquired
+	if fifo {
+		ticket = g.fifo.Ticket()
+	}
 	g.wg.Add(1)
+	g.stats.queued.Add(1)
 	go func() {
-		defer g.recovery()
 		defer g.wg.Done()
-		time.Sleep(g.jitter())
-		if g.concurrencyLimit != nil {
-			if err := g.concurrencyLimit.Acquire(g.ctx, 1); err != nil {
+		g.sleepJitter(t.info(g, TaskGo))
+		if !acquired {
+			err := g.admit(t, fifo, ticket)
+			if queued {
+				<-g.queue
+			}
+			if err != nil {
+				g.stats.queued.Add(-1)
+				g.untrack(t.index)
 				g.cancel(err)
 				return
 			}
-			defer g.concurrencyLimit.Release(1)
-		}
-		err := fn(g.ctx)
-		if err != nil {
-			g.cancel(err)
 		}
+		defer g.limiter.Release(t.cost)
+		g.stats.queued.Add(-1)
+		g.run(t.info(g, TaskGo), func(ctx context.Context) error { return g.call(ctx, t) })
 	}()
 }
 
+func (g *Tree) sleepJitter(info TaskInfo) {
+	if g.jitter != nil {
+		time.Sleep(g.jitter(g.ctx, info))
+	}
+}
+
+// admit waits until t is allowed to start, then acquires its slots from the
+// concurrency limit.
+func (g *Tree) admit(t task, fifo bool, ticket uint64) error {
+	if fifo {
+		defer g.fifo.Done(ticket)
+		if err := g.fifo.Wait(g.ctx, ticket); err != nil {
+			return err
+		}
+	}
+	if err := g.waitResumed(); err != nil {
+		return err
+	}
+	if g.rateLimit != nil {
+		if err := g.rateLimit.Wait(g.ctx); err != nil {
+			return err
+		}
+	}
+	if g.limiter.TryAcquire(t.cost) {
+		return nil
+	}
+	start := time.Now()
+	err := g.limiter.Acquire(g.ctx, t.cost, t.priority)
+	if g.metrics != nil {
+		g.metrics.LimitBlocked(time.Since(start))
+	}
+	return err
+}
+
+// call t.fn, restarting it according to the restart policy if any.
+func (g *Tree) call(ctx context.Context, t task) error {
+	if g.restart == nil {
+		return g.callOnce(ctx, t)
+	}
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = g.recovered(r)
+				}
+			}()
+			return g.callOnce(ctx, t)
+		}()
+		if err == nil || ctx.Err() != nil || attempt > g.restart.MaxRestarts {
+			return err
+		}
+		if g.restart.Backoff != nil {
+			delay = g.restart.Backoff(attempt, delay)
+			if !sleep(ctx.Done(), delay) {
+				return err
+			}
+		}
+	}
+}
+
+// callOnce calls t.fn, applying the task timeout if any.
+func (g *Tree) callOnce(ctx context.Context, t task) error {
+	if g.taskTimeout == 0 {
+		return t.fn(ctx)
+	}
+	tctx, cancel := context.WithTimeout(ctx, g.taskTimeout)
+	defer cancel()
+	err := t.fn(tctx)
+	if err != nil && errors.Is(tctx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		return &TaskTimeoutError{Name: t.name, Index: t.index, Timeout: g.taskTimeout, Err: err}
+	}
+	return err
+}
+
 // Link an existing Waiter to the tree.
 //
 // Useful for eg. syncing on an errgroup, or a separate Tree.
 func (g *Tree) Link(waiter Waiter) {
+	g.link(waiter, nil)
+}
+
+// LinkCancel is like Link, but cancel is also called with the cause if the
+// tree is cancelled, so that the waiter shares the lifecycle of the tree.
+//
+// eg. tree.LinkCancel(otherTree, otherTree.Cancel)
+func (g *Tree) LinkCancel(waiter Waiter, cancel func(cause error)) {
+	g.link(waiter, cancel)
+}
+
+// LinkContext cancels the tree with the cause of ctx when ctx is done.
+//
+// Unlike Link, the tree does not wait for ctx. The link is removed when Wait
+// returns.
+func (g *Tree) LinkContext(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() { g.cancel(context.Cause(ctx)) })
+	g.linksLock.Lock()
+	defer g.linksLock.Unlock()
+	g.links = append(g.links, stop)
+}
+
+func (g *Tree) link(waiter Waiter, cancel func(cause error)) {
+	if g.draining.Load() {
+		g.discarded(TaskLink)
+		return
+	}
+	g.checkSubmit()
+	t := task{}
+	g.track(&t, TaskLink)
 	g.wg.Add(1)
 	go func() {
-		defer g.recovery()
 		defer g.wg.Done()
-		err := waiter.Wait()
-		if err != nil {
-			g.cancel(err)
+		if err := g.waitResumed(); err != nil {
+			g.untrack(t.index)
+			if cancel != nil {
+				cancel(context.Cause(g.ctx))
+			}
+			return
 		}
+		g.run(t.info(g, TaskLink), func(ctx context.Context) error {
+			if cancel != nil {
+				stop := context.AfterFunc(ctx, func() { cancel(context.Cause(ctx)) })
+				defer stop()
+			}
+			return waiter.Wait()
+		})
 	}()
 }
 
@@ -108,52 +822,483 @@ func (g *Tree) Link(waiter Waiter) {
 // The sub-tree will inherit the options of the parent tree, but can override
 // them.
 //
-// Wait() is automatically called on the sub-tree when fn returns.
+// Wait() is automatically called on the sub-tree when fn returns. If fn
+// returns an error the sub-tree is cancelled.
 func (g *Tree) Sub(fn func(context.Context, *Tree) error, options ...Option) {
-	options = append(g.options, options...)
-	sub, ctx := New(g.ctx, options...)
+	g.sub("", fn, nil, options...)
+}
+
+// SubTagged is like Sub, but the sub-tree can be cancelled along with other
+// functions with the same tag by [Tree.CancelTag].
+func (g *Tree) SubTagged(tag string, fn func(context.Context, *Tree) error, options ...Option) {
+	g.sub(tag, fn, nil, options...)
+}
+
+// CancelTag cancels the contexts of all functions in the tree that were
+// submitted with tag, leaving the rest of the tree running.
+//
+// Functions that return the cancellation error are treated as successful.
+// Queued functions will start with a cancelled context.
+func (g *Tree) CancelTag(tag string, cause error) {
+	g.liveLock.Lock()
+	defer g.liveLock.Unlock()
+	for _, live := range g.live {
+		if live.info.Tag == tag && live.cancel != nil {
+			live.cancel(cause)
+		}
+	}
+}
+
+// sub starts fn in a sub-tree, returning false if it was discarded because the
+// tree is draining.
+//
+// If the tree is cancelled while paused, fn is not called and dropped, if
+// non-nil, is called with the cause instead.
+func (g *Tree) sub(tag string, fn func(context.Context, *Tree) error, dropped func(err error), options ...Option) bool {
+	if g.draining.Load() {
+		g.discarded(TaskSub)
+		return false
+	}
+	options = append(append([]Option{}, g.options...), options...)
+	g.checkSubmit()
+	t := task{tag: tag}
+	g.track(&t, TaskSub)
 	g.wg.Add(1)
 	go func() {
-		defer g.recovery()
 		defer g.wg.Done()
-		time.Sleep(g.jitter())
-		err := fn(ctx, sub)
-		cancelled := false
-		if err != nil {
-			g.cancel(err)
-			cancelled = true
-		}
-		err = sub.Wait()
-		if err != nil && !cancelled {
-			g.cancel(err)
+		g.sleepJitter(t.info(g, TaskSub))
+		if err := g.waitResumed(); err != nil {
+			g.untrack(t.index)
+			if dropped != nil {
+				dropped(context.Cause(g.ctx))
+			}
+			return
 		}
+		g.run(t.info(g, TaskSub), func(ctx context.Context) error {
+			sub, ctx := New(ctx, options...)
+			g.addChild(sub)
+			defer g.removeChild(sub)
+			// Recover here so that the sub-tree is still waited if fn panics.
+			err := func() (err error) {
+				defer func() {
+					if r := recover(); r != nil {
+						err = g.recovered(r)
+					}
+				}()
+				return fn(ctx, sub)
+			}()
+			if err != nil {
+				sub.cancel(err)
+			}
+			if serr := sub.Wait(); err == nil {
+				err = serr
+			}
+			if err != nil && sub.isolated {
+				g.errorsLock.Lock()
+				g.isolatedErrors = append(g.isolatedErrors, err)
+				g.errorsLock.Unlock()
+				return nil
+			}
+			return err
+		})
 	}()
+	return true
+}
+
+// IsolatedErrors returns the errors from sub-trees created with [Isolated].
+//
+// It should be called after Wait.
+func (g *Tree) IsolatedErrors() []error {
+	g.errorsLock.Lock()
+	defer g.errorsLock.Unlock()
+	return append([]error(nil), g.isolatedErrors...)
+}
+
+// Stats returns a snapshot of the state of functions in the tree.
+//
+// Functions started with Go, Sub and Link are all counted, but functions
+// within sub-trees are not.
+func (g *Tree) Stats() Stats {
+	return Stats{
+		Running:   g.stats.running.Load(),
+		Queued:    g.stats.queued.Load(),
+		Completed: g.stats.completed.Load(),
+		Failed:    g.stats.failed.Load(),
+	}
 }
 
-// Wait for the tree to finish, and return the results of all successful calls.
+// Wait for all functions in the tree to finish.
 //
-// Results will be returned in the order in which Go() was called. Failing taks
-// will leave zero values in the result slice.
+// Unlike errgroup this will return the first error returned by a user function,
+// not context.Canceled. If [WithCollectErrors] is set, all errors are returned.
 //
-// Unlike errtree this will return the first error returned by a user function,
-// not context.Canceled.
+// Calling Wait from within a function in the tree returns [ErrWaitInTask].
 func (g *Tree) Wait() error {
+	if err := g.checkWait(); err != nil {
+		return err
+	}
+	if g.onHung != nil {
+		timer := time.AfterFunc(g.hungThreshold, func() { g.onHung(g.running(g.hungStacks)) })
+		defer timer.Stop()
+	}
 	g.wg.Wait()
-	err := g.ctx.Err()
-	if err == nil {
+	g.waited.Store(true)
+	g.linksLock.Lock()
+	for _, stop := range g.links {
+		stop()
+	}
+	g.links = nil
+	g.linksLock.Unlock()
+	if g.ctx.Err() != nil {
+		// Report cancellation of parent contexts.
+		g.cancelled()
+	}
+	err := g.result()
+	g.runDeferred(err)
+	return err
+}
+
+// Defer registers fn to be called with the result of Wait once all functions
+// in the tree have completed, before Wait returns.
+//
+// Deferred functions are called in the reverse order to which they were
+// registered, as with the defer statement.
+func (g *Tree) Defer(fn func(err error)) {
+	g.deferredLock.Lock()
+	defer g.deferredLock.Unlock()
+	g.deferred = append(g.deferred, fn)
+}
+
+// runDeferred calls the deferred functions once, blocking until they have
+// returned even if they were called by a concurrent Wait.
+func (g *Tree) runDeferred(err error) {
+	g.deferredLock.Lock()
+	deferred, running := g.deferred, g.deferredDone
+	if len(deferred) == 0 {
+		g.deferredLock.Unlock()
+		if running != nil {
+			<-running
+		}
+		return
+	}
+	g.deferred = nil
+	done := make(chan struct{})
+	g.deferredDone = done
+	g.deferredLock.Unlock()
+	defer close(done)
+	if running != nil {
+		<-running
+	}
+	for i := len(deferred) - 1; i >= 0; i-- {
+		deferred[i](err)
+	}
+}
+
+// result returns the error that Wait should return.
+func (g *Tree) result() error {
+	g.errorsLock.Lock()
+	errs := g.errors
+	g.errorsLock.Unlock()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	if g.ctx.Err() == nil {
 		return nil
-	} else if errors.Is(err, context.Canceled) && context.Cause(g.ctx) != nil {
-		return context.Cause(g.ctx)
+	}
+	return context.Cause(g.ctx)
+}
+
+// WaitAny waits for the first function in the tree to complete, then cancels
+// the tree and waits for the remaining functions to finish.
+//
+// The error returned by the first function is returned, or nil if it
+// succeeded. If the tree has no functions the result of Wait is returned.
+func (g *Tree) WaitAny() error {
+	if err := g.checkWait(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	for {
+		g.completionLock.Lock()
+		completions, firstErr, completion := g.completions, g.firstErr, g.completion
+		g.completionLock.Unlock()
+		if completions > 0 {
+			g.cancel(context.Canceled)
+			<-done
+			return firstErr
+		}
+		select {
+		case <-completion:
+		case err := <-done:
+			g.completionLock.Lock()
+			completions, firstErr = g.completions, g.firstErr
+			g.completionLock.Unlock()
+			if completions > 0 {
+				return firstErr
+			}
+			return err
+		}
+	}
+}
+
+// WaitOption configures [Tree.WaitN].
+type WaitOption func(*waitOptions)
+
+type waitOptions struct {
+	cancelRemaining bool
+}
+
+// CancelRemaining cancels the tree and waits for functions that are still
+// running once [Tree.WaitN] is satisfied.
+func CancelRemaining() WaitOption {
+	return func(o *waitOptions) {
+		o.cancelRemaining = true
+	}
+}
+
+// WaitN waits until n functions in the tree have completed successfully.
+//
+// By default the remaining functions are left running, and Wait should still
+// be called. If the tree completes or is cancelled before n functions have
+// succeeded, its error is returned, or an error if it completed without one.
+func (g *Tree) WaitN(n int, options ...WaitOption) error {
+	if err := g.checkWait(); err != nil {
+		return err
+	}
+	opts := waitOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	for {
+		g.completionLock.Lock()
+		completion := g.completion
+		g.completionLock.Unlock()
+		if g.stats.completed.Load() >= int64(n) {
+			if opts.cancelRemaining {
+				g.cancel(context.Canceled)
+				<-done
+			}
+			return nil
+		}
+		select {
+		case <-completion:
+		case err := <-done:
+			if succeeded := g.stats.completed.Load(); succeeded < int64(n) {
+				if err == nil {
+					err = fmt.Errorf("concurrency: only %d of %d functions succeeded", succeeded, n)
+				}
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// WaitContext is like Wait, but gives up waiting when ctx is done.
+//
+// If ctx is done before the tree completes the returned error will wrap both
+// [ErrWaitTimeout] and the cause of ctx. The tree is left running.
+func (g *Tree) WaitContext(ctx context.Context) error {
+	if err := g.checkWait(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- g.Wait() }()
+	select {
+	case err := <-done:
+		return err
+
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrWaitTimeout, context.Cause(ctx))
+	}
+}
+
+// WaitTimeout is like Wait, but gives up waiting after timeout.
+//
+// See [Tree.WaitContext] for details.
+func (g *Tree) WaitTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return g.WaitContext(ctx)
+}
+
+// Cancel the tree with cause, which will be returned by Wait.
+//
+// If cause is nil the tree is cancelled with [context.Canceled].
+func (g *Tree) Cancel(cause error) {
+	g.cancel(cause)
+}
+
+// Drain stops the tree accepting new functions, then waits for those already
+// submitted to complete.
+//
+// Once draining, functions passed to Go, Sub, Link, etc. are discarded without
+// being called, and TryGo returns false. Use [WithOnDiscard] to be notified of
+// discarded functions. If ctx is done before the tree
+// completes, the tree is cancelled and Drain waits for it to finish.
+func (g *Tree) Drain(ctx context.Context) error {
+	g.draining.Store(true)
+	err := g.WaitContext(ctx)
+	if errors.Is(err, ErrWaitTimeout) {
+		g.cancel(err)
+		return g.Wait()
 	}
 	return err
 }
 
-func (g *Tree) recovery() {
-	if r := recover(); r != nil {
-		if err, ok := r.(error); ok {
-			g.cancel(err)
+// cancelled reports that the tree has been cancelled, once.
+func (g *Tree) cancelled() {
+	g.cancelOnce.Do(func() {
+		g.logCancel()
+		if g.metrics != nil {
+			g.metrics.TreeCancelled(context.Cause(g.ctx))
+		}
+	})
+}
+
+// fail records err if errors are being collected, then cancels the tree if
+// the error threshold has been exceeded.
+func (g *Tree) fail(err error) {
+	failures := 1
+	if g.collectErrors {
+		// Ignore errors that are just a consequence of the tree being cancelled.
+		if ctxErr := g.ctx.Err(); ctxErr == nil || !errors.Is(err, ctxErr) {
+			g.errorsLock.Lock()
+			g.errors = append(g.errors, err)
+			failures = len(g.errors)
+			g.errorsLock.Unlock()
+		}
+	}
+	if failures > g.errorThreshold {
+		g.cancel(err)
+	}
+}
+
+// run fn, recording its outcome and recovering from any panic.
+//
+// If the task has a name it is used to annotate the error.
+func (g *Tree) run(info TaskInfo, fn func(context.Context) error) {
+	name := info.Name
+	defer g.enter(info)()
+	ctx := g.started(info.Index)
+	defer g.untrack(info.Index)
+	g.stats.running.Add(1)
+	start := time.Now()
+	if g.onTaskStart != nil {
+		g.onTaskStart(g.ctx, name)
+	}
+	g.logTaskStart(name)
+	if g.metrics != nil {
+		g.metrics.TaskStarted()
+	}
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = g.recovered(r)
+		}
+		g.stats.running.Add(-1)
+		if err != nil {
+			err = g.annotate(name, err)
+		}
+		duration := time.Since(start)
+		if g.onTaskDone != nil {
+			g.onTaskDone(g.ctx, name, err, duration)
+		}
+		g.logTaskDone(name, err, duration)
+		if g.metrics != nil {
+			g.metrics.TaskFinished(duration, err)
+		}
+		if err != nil {
+			g.stats.failed.Add(1)
+			g.fail(err)
 		} else {
-			g.cancel(fmt.Errorf("worktree: panic: %v", r))
+			g.stats.completed.Add(1)
+		}
+		g.notifyCompletion(err)
+	}()
+	err = g.intercept(ctx, info, fn)
+	if err != nil && ctx != g.ctx && g.ctx.Err() == nil && ctx.Err() != nil &&
+		(errors.Is(err, ctx.Err()) || errors.Is(err, context.Cause(ctx))) {
+		// Cancelled by CancelTag.
+		err = nil
+	}
+}
+
+// annotate err with the name of the task that returned it.
+func (g *Tree) annotate(name string, err error) error {
+	var timeoutErr *TaskTimeoutError
+	if errors.As(err, &timeoutErr) && timeoutErr.Name == name {
+		// Already named.
+		name = ""
+	}
+	if !g.errorPaths {
+		if name != "" {
+			return fmt.Errorf("task %s: %w", name, err)
+		}
+		return err
+	}
+	var taskErr *TaskError
+	if errors.As(err, &taskErr) {
+		return err
+	}
+	path := g.path()
+	if name != "" {
+		path = append(path, name)
+	}
+	if len(path) == 0 {
+		return err
+	}
+	return &TaskError{Path: path, Err: err}
+}
+
+// path returns the names of the tree and its ancestors, from the root.
+func (g *Tree) path() []string {
+	var path []string
+	for t := g; t != nil; t = t.parent {
+		if t.name != "" {
+			path = append([]string{t.name}, path...)
 		}
 	}
+	return path
+}
+
+func (g *Tree) notifyCompletion(err error) {
+	g.completionLock.Lock()
+	defer g.completionLock.Unlock()
+	if g.completions == 0 {
+		g.firstErr = err
+	}
+	g.completions++
+	close(g.completion)
+	g.completion = make(chan struct{})
+}
+
+// recovered converts the recovered panic value r into a [PanicError], or
+// panics again if recovery is disabled by [WithoutRecover].
+func (g *Tree) recovered(r any) error {
+	if g.noRecover {
+		panic(r)
+	}
+	return newPanicError(r)
+}
+
+// intercept calls fn through the tree's interceptors, converting panics into
+// errors so that interceptors can observe them.
+func (g *Tree) intercept(ctx context.Context, info TaskInfo, fn func(context.Context) error) error {
+	next := func(ctx context.Context) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = g.recovered(r)
+			}
+		}()
+		return fn(ctx)
+	}
+	for i := len(g.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := g.interceptors[i], next
+		next = func(ctx context.Context) error { return interceptor(ctx, info, inner) }
+	}
+	return next(ctx)
 }
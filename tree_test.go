@@ -2,9 +2,15 @@ package concurrency
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -111,3 +117,2343 @@ func TestCancelCause(t *testing.T) {
 	err := wg.Wait()
 	assert.EqualError(t, err, "error")
 }
+
+func TestCollectErrors(t *testing.T) {
+	t.Parallel()
+	errA := fmt.Errorf("a")
+	errB := fmt.Errorf("b")
+	wg, _ := New(context.Background(), WithCollectErrors())
+	wg.Go(func(ctx context.Context) error {
+		return errA
+	})
+	wg.Go(func(ctx context.Context) error {
+		return errB
+	})
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := wg.Wait()
+	assert.IsError(t, err, errA)
+	assert.IsError(t, err, errB)
+	assert.NotIsError(t, err, context.Canceled)
+}
+
+func TestContinueOnError(t *testing.T) {
+	t.Parallel()
+	errA := fmt.Errorf("a")
+	wg, ctx := New(context.Background(), WithContinueOnError())
+	wg.Go(func(ctx context.Context) error {
+		return errA
+	})
+	completed := false
+	wg.Go(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 10)
+		completed = ctx.Err() == nil
+		return nil
+	})
+	err := wg.Wait()
+	assert.IsError(t, err, errA)
+	assert.True(t, completed)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestTreeFromContext(t *testing.T) {
+	t.Parallel()
+	wg, ctx := New(context.Background())
+	actual, ok := TreeFromContext(ctx)
+	assert.True(t, ok)
+	assert.True(t, wg == actual)
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		actual, ok := TreeFromContext(ctx)
+		assert.True(t, ok)
+		assert.True(t, sg == actual)
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	_, ok = TreeFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestTryGo(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1))
+	release := make(chan struct{})
+	assert.True(t, wg.TryGo(func(ctx context.Context) error {
+		<-release
+		return nil
+	}))
+	assert.False(t, wg.TryGo(func(ctx context.Context) error { return nil }))
+	close(release)
+	assert.NoError(t, wg.Wait())
+	assert.True(t, wg.TryGo(func(ctx context.Context) error { return nil }))
+	assert.NoError(t, wg.Wait())
+}
+
+func TestSetConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1))
+	assert.Equal(t, 1, wg.ConcurrencyLimit())
+	var running, peak int32
+	var lock sync.Mutex
+	for i := 0; i < 8; i++ {
+		wg.Go(func(ctx context.Context) error {
+			lock.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			lock.Unlock()
+			time.Sleep(time.Millisecond * 20)
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return nil
+		})
+	}
+	wg.SetConcurrencyLimit(4)
+	assert.Equal(t, 4, wg.ConcurrencyLimit())
+	assert.NoError(t, wg.Wait())
+	assert.True(t, peak > 1 && peak <= 4, "peak concurrency %d", peak)
+}
+
+func TestQueueLimit(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1), WithQueueLimit(1))
+	release := make(chan struct{})
+	block := func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+	wg.Go(block)
+	// Wait for the first function to start so the next one is queued.
+	for wg.limiter.TryAcquire(1) {
+		wg.limiter.Release(1)
+		time.Sleep(time.Millisecond)
+	}
+	wg.Go(block)
+	submitted := make(chan struct{})
+	go func() {
+		wg.Go(block)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("Go should block while the queue is full")
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(release)
+	<-submitted
+	assert.NoError(t, wg.Wait())
+}
+
+func TestPauseResume(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Pause()
+	started := make(chan struct{})
+	wg.Go(func(ctx context.Context) error {
+		close(started)
+		return nil
+	})
+	assert.False(t, wg.TryGo(func(ctx context.Context) error { return nil }))
+	select {
+	case <-started:
+		t.Fatal("function should not start while paused")
+	case <-time.After(time.Millisecond * 50):
+	}
+	wg.Resume()
+	<-started
+	assert.NoError(t, wg.Wait())
+}
+
+func TestPauseSubLink(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Pause()
+	var subStarted atomic.Bool
+	wg.Sub(func(ctx context.Context, sub *Tree) error {
+		subStarted.Store(true)
+		return nil
+	})
+	other, _ := New(context.Background())
+	other.Go(func(ctx context.Context) error { return errors.New("linked") })
+	wg.Link(other)
+	time.Sleep(time.Millisecond * 50)
+	assert.False(t, subStarted.Load())
+	assert.Equal(t, int64(0), wg.Stats().Failed)
+	wg.Resume()
+	assert.EqualError(t, wg.Wait(), "linked")
+	assert.True(t, subStarted.Load())
+}
+
+func TestWaitTimeout(t *testing.T) {
+	t.Parallel()
+	wg, ctx := New(context.Background())
+	release := make(chan struct{})
+	wg.Go(func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	err := wg.WaitTimeout(time.Millisecond * 10)
+	assert.IsError(t, err, ErrWaitTimeout)
+	assert.IsError(t, err, context.DeadlineExceeded)
+	assert.NoError(t, ctx.Err())
+	close(release)
+	assert.NoError(t, wg.WaitContext(context.Background()))
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithContinueOnError(), WithConcurrencyLimit(1))
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wg.Go(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	wg.Go(func(ctx context.Context) error { return fmt.Errorf("error") })
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		<-release
+		return nil
+	})
+	time.Sleep(time.Millisecond * 20)
+	assert.Equal(t, Stats{Running: 2, Queued: 1}, wg.Stats())
+	close(release)
+	assert.Error(t, wg.Wait())
+	assert.Equal(t, Stats{Completed: 2, Failed: 1}, wg.Stats())
+}
+
+func TestGoNamed(t *testing.T) {
+	t.Parallel()
+	errA := fmt.Errorf("error")
+	wg, _ := New(context.Background())
+	wg.GoNamed("worker", func(ctx context.Context) error {
+		return errA
+	})
+	err := wg.Wait()
+	assert.EqualError(t, err, "task worker: error")
+	assert.IsError(t, err, errA)
+
+	wg, _ = New(context.Background())
+	wg.GoNamed("worker", func(ctx context.Context) error {
+		panic("boom")
+	})
+	assert.EqualError(t, wg.Wait(), "task worker: panic: boom")
+}
+
+func TestPanicError(t *testing.T) {
+	t.Parallel()
+	errA := fmt.Errorf("error")
+	wg, _ := New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		panic(errA)
+	})
+	err := wg.Wait()
+	var perr *PanicError
+	assert.True(t, errors.As(err, &perr))
+	assert.Equal(t, any(errA), perr.Value)
+	assert.Contains(t, string(perr.Stack), "TestPanicError")
+	assert.IsError(t, err, errA)
+}
+
+func TestGoN(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	workers := make([]bool, 4)
+	wg.GoN(len(workers), func(ctx context.Context, worker int) error {
+		workers[worker] = true
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, []bool{true, true, true, true}, workers)
+}
+
+func TestGoWeighted(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(4))
+	var running, peak int64
+	var lock sync.Mutex
+	track := func(cost int64) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			lock.Lock()
+			running += cost
+			if running > peak {
+				peak = running
+			}
+			lock.Unlock()
+			time.Sleep(time.Millisecond * 10)
+			lock.Lock()
+			running -= cost
+			lock.Unlock()
+			return nil
+		}
+	}
+	for i := 0; i < 4; i++ {
+		wg.GoWeighted(3, track(3))
+		wg.Go(track(1))
+	}
+	wg.GoWeighted(8, track(8))
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, int64(8), peak)
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithRateLimit(100, 1))
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		wg.Go(func(ctx context.Context) error { return nil })
+	}
+	assert.NoError(t, wg.Wait())
+	assert.True(t, time.Since(start) >= time.Millisecond*50, "%s elapsed", time.Since(start))
+}
+
+func TestTaskTimeout(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithTaskTimeout(time.Millisecond*10))
+	wg.Go(func(ctx context.Context) error { return nil })
+	wg.GoNamed("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := wg.Wait()
+	assert.EqualError(t, err, "task slow timed out after 10ms: context deadline exceeded")
+	var terr *TaskTimeoutError
+	assert.True(t, errors.As(err, &terr))
+	assert.Equal(t, &TaskTimeoutError{Name: "slow", Index: 1, Timeout: time.Millisecond * 10, Err: context.DeadlineExceeded}, terr)
+	assert.IsError(t, err, context.DeadlineExceeded)
+
+	wg, _ = New(context.Background(), WithTaskTimeout(time.Millisecond*10))
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return errors.New("gave up")
+	})
+	err = wg.Wait()
+	assert.EqualError(t, err, "task 0 timed out after 10ms: gave up")
+	assert.IsError(t, err, context.DeadlineExceeded)
+}
+
+func TestTreeTimeout(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithTimeout(time.Millisecond*10))
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err := wg.Wait()
+	var terr *TreeTimeoutError
+	assert.True(t, errors.As(err, &terr), "%v", err)
+	assert.IsError(t, err, context.DeadlineExceeded)
+
+	wg, _ = New(context.Background(), WithTimeout(time.Millisecond*10))
+	wg.Go(func(ctx context.Context) error { return nil })
+	assert.NoError(t, wg.Wait())
+
+	wg, _ = New(context.Background(), WithTimeout(time.Second))
+	hasDeadline := false
+	wg.Go(func(ctx context.Context) error {
+		_, hasDeadline = ctx.Deadline()
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.True(t, hasDeadline)
+}
+
+func TestErrorThreshold(t *testing.T) {
+	t.Parallel()
+	wg, ctx := New(context.Background(), WithErrorThreshold(2))
+	wg.GoN(2, func(ctx context.Context, worker int) error {
+		return fmt.Errorf("error %d", worker)
+	})
+	time.Sleep(time.Millisecond * 10)
+	assert.NoError(t, ctx.Err())
+	wg.Go(func(ctx context.Context) error { return fmt.Errorf("error 2") })
+	err := wg.Wait()
+	assert.Error(t, ctx.Err())
+	assert.Equal(t, 3, len(err.(interface{ Unwrap() []error }).Unwrap()))
+}
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	finished := false
+	wg.Go(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 10)
+		finished = true
+		return nil
+	})
+	assert.NoError(t, wg.Drain(context.Background()))
+	assert.True(t, finished)
+	called := false
+	wg.Go(func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	assert.False(t, wg.TryGo(func(ctx context.Context) error { return nil }))
+	assert.NoError(t, wg.Wait())
+	assert.False(t, called)
+
+	wg, _ = New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.IsError(t, wg.Drain(ctx), ErrWaitTimeout)
+}
+
+func TestDrainDiscard(t *testing.T) {
+	t.Parallel()
+	var discarded []TaskKind
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1), WithOnDiscard(func(err *DiscardedError) {
+		discarded = append(discarded, err.Kind)
+		assert.Contains(t, err.File, "tree_test.go")
+	}))
+	assert.NoError(t, wg.Drain(context.Background()))
+	wg.Go(func(ctx context.Context) error { return nil })
+	wg.Sub(func(ctx context.Context, sub *Tree) error { return nil })
+	other, _ := New(context.Background())
+	wg.Link(other)
+	assert.Equal(t, []TaskKind{TaskGo, TaskSub, TaskLink}, discarded)
+
+	// Simulate TryGo racing with Drain after acquiring its slot.
+	assert.True(t, wg.limiter.TryAcquire(1))
+	wg.spawn(task{cost: 1, fn: func(ctx context.Context) error { return nil }}, true)
+	assert.True(t, wg.limiter.TryAcquire(1))
+}
+
+func TestTreeCancel(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	wg.Cancel(fmt.Errorf("shutdown"))
+	assert.EqualError(t, wg.Wait(), "shutdown")
+}
+
+func TestGoPriority(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1))
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wg.Go(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	order := make(chan int, 3)
+	for _, priority := range []int{0, 2, 1} {
+		priority := priority
+		wg.GoPriority(priority, func(ctx context.Context) error {
+			order <- priority
+			return nil
+		})
+	}
+	for {
+		wg.limiter.lock.Lock()
+		waiting := wg.limiter.waiters.Len()
+		wg.limiter.lock.Unlock()
+		if waiting == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	assert.NoError(t, wg.Wait())
+	close(order)
+	actual := []int{}
+	for priority := range order {
+		actual = append(actual, priority)
+	}
+	assert.Equal(t, []int{2, 1, 0}, actual)
+}
+
+func TestFIFO(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1), WithFIFO())
+	order := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Go(func(ctx context.Context) error {
+			order <- i
+			return nil
+		})
+	}
+	assert.NoError(t, wg.Wait())
+	close(order)
+	expected := 0
+	for i := range order {
+		assert.Equal(t, expected, i)
+		expected++
+	}
+}
+
+func TestTaskHooks(t *testing.T) {
+	t.Parallel()
+	var lock sync.Mutex
+	events := []string{}
+	wg, _ := New(context.Background(),
+		WithOnTaskStart(func(ctx context.Context, name string) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, "start "+name)
+		}),
+		WithOnTaskDone(func(ctx context.Context, name string, err error, duration time.Duration) {
+			lock.Lock()
+			defer lock.Unlock()
+			events = append(events, fmt.Sprintf("done %s %v", name, err))
+		}))
+	wg.GoNamed("worker", func(ctx context.Context) error {
+		return fmt.Errorf("error")
+	})
+	assert.Error(t, wg.Wait())
+	assert.Equal(t, []string{"start worker", "done worker task worker: error"}, events)
+}
+
+func TestLogger(t *testing.T) {
+	t.Parallel()
+	w := &strings.Builder{}
+	logger := slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	wg, _ := New(context.Background(), WithName("root"), WithLogger(logger))
+	wg.GoNamed("worker", func(ctx context.Context) error {
+		return fmt.Errorf("error")
+	})
+	assert.Error(t, wg.Wait())
+	assert.Equal(t, `level=DEBUG msg="Task started" task=worker tree=root
+level=ERROR msg="Task failed" task=worker error="task worker: error" tree=root
+level=INFO msg="Tree cancelled" cause="task worker: error" tree=root
+`, w.String())
+}
+
+func TestInterceptor(t *testing.T) {
+	t.Parallel()
+	type key struct{}
+	var lock sync.Mutex
+	seen := []string{}
+	wg, _ := New(context.Background(), WithInterceptor(func(ctx context.Context, task TaskInfo, next func(context.Context) error) error {
+		err := next(context.WithValue(ctx, key{}, task.Kind.String()))
+		lock.Lock()
+		defer lock.Unlock()
+		seen = append(seen, fmt.Sprintf("%s %s %v", task.Kind, task.Name, err))
+		return err
+	}))
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		assert.Equal(t, "sub", ctx.Value(key{}))
+		sg.GoNamed("panic", func(ctx context.Context) error {
+			assert.Equal(t, "go", ctx.Value(key{}))
+			panic("boom")
+		})
+		return nil
+	})
+	assert.Error(t, wg.Wait())
+	sort.Strings(seen)
+	assert.Equal(t, []string{"go panic panic: boom", "sub  task panic: panic: boom"}, seen)
+}
+
+type testMetrics struct {
+	lock     sync.Mutex
+	started  int
+	finished int
+	failed   int
+	blocked  int
+	cause    error
+	sent     int
+	dropped  int
+}
+
+func (m *testMetrics) ValueSent(blocked time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sent++
+}
+
+func (m *testMetrics) ValueDropped() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.dropped++
+}
+
+func (m *testMetrics) TaskStarted() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.started++
+}
+
+func (m *testMetrics) TaskFinished(duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.finished++
+	if err != nil {
+		m.failed++
+	}
+}
+
+func (m *testMetrics) LimitBlocked(duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.blocked++
+}
+
+func (m *testMetrics) TreeCancelled(cause error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cause = cause
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	metrics := &testMetrics{}
+	wg, _ := New(context.Background(), WithMetrics(metrics), WithConcurrencyLimit(1))
+	release := make(chan struct{})
+	started := make(chan struct{})
+	wg.Go(func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	wg.Go(func(ctx context.Context) error { return fmt.Errorf("error") })
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+	assert.EqualError(t, wg.Wait(), "error")
+	assert.Equal(t, 2, metrics.started)
+	assert.Equal(t, 2, metrics.finished)
+	assert.Equal(t, 1, metrics.failed)
+	assert.Equal(t, 1, metrics.blocked)
+	assert.EqualError(t, metrics.cause, "error")
+}
+
+func TestJitterFunc(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithJitterFunc(func(ctx context.Context, task TaskInfo) time.Duration {
+		return time.Duration(task.Index) * time.Millisecond * 10
+	}))
+	start := time.Now()
+	wg.GoN(4, func(ctx context.Context, worker int) error { return nil })
+	assert.NoError(t, wg.Wait())
+	assert.True(t, time.Since(start) >= time.Millisecond*30, "%s elapsed", time.Since(start))
+}
+
+func TestJitterStrategies(t *testing.T) {
+	t.Parallel()
+	uniform := UniformJitter(time.Millisecond, time.Millisecond*2)
+	decorrelated := DecorrelatedJitter(time.Millisecond, time.Millisecond*10)
+	for i := 0; i < 100; i++ {
+		delay := uniform()
+		assert.True(t, delay >= time.Millisecond && delay < time.Millisecond*2, "%s", delay)
+		delay = decorrelated()
+		assert.True(t, delay >= time.Millisecond && delay <= time.Millisecond*10, "%s", delay)
+	}
+	ramp := RampJitter(time.Millisecond)
+	assert.Equal(t, time.Millisecond*3, ramp(context.Background(), TaskInfo{Index: 3}))
+}
+
+func TestIsolated(t *testing.T) {
+	t.Parallel()
+	wg, ctx := New(context.Background())
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		sg.Go(func(ctx context.Context) error { return fmt.Errorf("tenant failed") })
+		return nil
+	}, Isolated())
+	completed := false
+	wg.Go(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 10)
+		completed = ctx.Err() == nil
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.NoError(t, ctx.Err())
+	assert.True(t, completed)
+	errs := wg.IsolatedErrors()
+	assert.Equal(t, 1, len(errs))
+	assert.EqualError(t, errs[0], "tenant failed")
+}
+
+func TestSubResult(t *testing.T) {
+	t.Parallel()
+	wg, ctx := New(context.Background())
+	future := SubResult(wg, func(ctx context.Context, sg *Tree) (int, error) {
+		results, err := Map(sg, []int{1, 2, 3}, func(ctx context.Context, n int) (int, error) {
+			return n * 2, nil
+		})
+		sum := 0
+		for _, n := range results {
+			sum += n
+		}
+		return sum, err
+	})
+	value, err := future.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, value)
+	assert.NoError(t, wg.Wait())
+}
+
+func TestRestart(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithRestart(RestartPolicy{
+		MaxRestarts: 3,
+		Backoff:     ExponentialBackoff(time.Millisecond, time.Millisecond*10),
+	}))
+	attempts := 0
+	wg.Go(func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			panic("transient")
+		}
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 3, attempts)
+
+	wg, _ = New(context.Background(), WithRestart(RestartPolicy{MaxRestarts: 2}))
+	attempts = 0
+	wg.Go(func(ctx context.Context) error {
+		attempts++
+		return fmt.Errorf("permanent")
+	})
+	assert.EqualError(t, wg.Wait(), "permanent")
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWaitAny(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	wg.Go(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 10)
+		return nil
+	})
+	assert.NoError(t, wg.WaitAny())
+
+	wg, _ = New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		return fmt.Errorf("error")
+	})
+	assert.EqualError(t, wg.WaitAny(), "error")
+
+	wg, _ = New(context.Background())
+	assert.NoError(t, wg.WaitAny())
+}
+
+func TestWaitN(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.GoN(2, func(ctx context.Context, worker int) error { return nil })
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.NoError(t, wg.WaitN(2, CancelRemaining()))
+
+	wg, _ = New(context.Background())
+	wg.GoN(2, func(ctx context.Context, worker int) error { return nil })
+	assert.EqualError(t, wg.WaitN(3), "concurrency: only 2 of 3 functions succeeded")
+}
+
+func TestWaitInTask(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		sg.Go(func(ctx context.Context) error {
+			return wg.Wait()
+		})
+		return sg.Wait()
+	})
+	assert.IsError(t, wg.Wait(), ErrWaitInTask)
+	// Goroutines are only tracked per tree for hung task diagnostics.
+	assert.Equal(t, 0, len(wg.goroutines))
+
+	wg, _ = New(context.Background())
+	wg.Go(func(ctx context.Context) error {
+		nested, _ := New(ctx)
+		nested.Go(func(ctx context.Context) error { return wg.Wait() })
+		return nested.Wait()
+	})
+	assert.IsError(t, wg.Wait(), ErrWaitInTask)
+}
+
+func TestHungTaskDiagnostics(t *testing.T) {
+	t.Parallel()
+	diagnostics := make(chan []RunningTask, 1)
+	wg, _ := New(context.Background(), WithHungTaskDiagnostics(time.Millisecond*10, true, func(running []RunningTask) {
+		diagnostics <- running
+	}))
+	release := make(chan struct{})
+	wg.GoNamed("hung", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	go func() {
+		running := <-diagnostics
+		assert.Equal(t, 1, len(running))
+		assert.Equal(t, "hung", running[0].Task.Name)
+		assert.Contains(t, string(running[0].Stack), "TestHungTaskDiagnostics")
+		close(release)
+	}()
+	assert.NoError(t, wg.Wait())
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithName("root"), WithConcurrencyLimit(2))
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	wg.GoNamed("running", func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		sg.GoNamed("child", func(ctx context.Context) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+		return sg.Wait()
+	}, WithName("sub"))
+	<-started
+	<-started
+	wg.GoNamed("queued", func(ctx context.Context) error { return nil })
+
+	snapshot := wg.Snapshot()
+	assert.Equal(t, "root", snapshot.Name)
+	assert.Equal(t, 3, len(snapshot.Tasks))
+	assert.Equal(t, "running", snapshot.Tasks[0].Task.Name)
+	assert.Equal(t, TaskRunning, snapshot.Tasks[0].State)
+	assert.Equal(t, TaskSub, snapshot.Tasks[1].Task.Kind)
+	assert.Equal(t, "queued", snapshot.Tasks[2].Task.Name)
+	assert.Equal(t, TaskQueued, snapshot.Tasks[2].State)
+	assert.Equal(t, 1, len(snapshot.Children))
+	assert.Equal(t, "sub", snapshot.Children[0].Name)
+	assert.Equal(t, 1, len(snapshot.Children[0].Tasks))
+	assert.Equal(t, "child", snapshot.Children[0].Tasks[0].Task.Name)
+
+	close(release)
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, TreeSnapshot{Name: "root", Stats: Stats{Completed: 3}}, wg.Snapshot())
+}
+
+func TestReset(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithTimeout(time.Second))
+	wg.Go(func(ctx context.Context) error { return errors.New("failed") })
+	assert.EqualError(t, wg.Wait(), "failed")
+
+	ctx := wg.Reset()
+	assert.NoError(t, ctx.Err())
+	assert.Equal(t, Stats{}, wg.Stats())
+	wg.Go(func(ctx context.Context) error { return nil })
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, Stats{Completed: 1}, wg.Stats())
+}
+
+func TestGoAfterWait(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.Cancel(errors.New("cancelled"))
+	assert.Error(t, wg.Wait())
+	assert.Panics(t, func() { wg.Go(func(ctx context.Context) error { return nil }) })
+
+	var reported *GoAfterWaitError
+	wg, _ = New(context.Background(), WithOnGoAfterWait(func(err *GoAfterWaitError) { reported = err }))
+	wg.Go(func(ctx context.Context) error { return errors.New("failed") })
+	assert.Error(t, wg.Wait())
+	wg.Sub(func(ctx context.Context, sg *Tree) error { return nil })
+	assert.NotZero(t, reported)
+	assert.True(t, strings.HasSuffix(reported.File, "tree_test.go"))
+	assert.Error(t, wg.Wait())
+
+	wg.Reset()
+	reported = nil
+	wg.Go(func(ctx context.Context) error { return nil })
+	assert.Zero(t, reported)
+	assert.NoError(t, wg.Wait())
+}
+
+func TestGroup(t *testing.T) {
+	t.Parallel()
+	var zero Group
+	zero.SetLimit(1)
+	zero.Go(func() error { return nil })
+	assert.NoError(t, zero.Wait())
+	assert.True(t, zero.TryGo(func() error { return nil }))
+	assert.NoError(t, zero.Wait())
+	zero.Go(func() error { return errors.New("failed") })
+	assert.EqualError(t, zero.Wait(), "failed")
+	zero.Go(func() error { return nil })
+	assert.EqualError(t, zero.Wait(), "failed")
+
+	wg, ctx := NewGroup(context.Background())
+	wg.Go(func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	wg.Go(func() error { return errors.New("failed") })
+	assert.EqualError(t, wg.Wait(), "failed")
+
+	wg, ctx = NewGroup(context.Background())
+	wg.Go(func() error { return nil })
+	assert.NoError(t, wg.Wait())
+	assert.IsError(t, ctx.Err(), context.Canceled)
+	// Reuse after Wait, as with errgroup.
+	called := false
+	wg.Go(func() error {
+		called = true
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.True(t, called)
+
+	var limited Group
+	limited.SetLimit(0)
+	assert.False(t, limited.TryGo(func() error { return nil }))
+	limited.SetLimit(-1)
+	assert.True(t, limited.TryGo(func() error { return nil }))
+	assert.NoError(t, limited.Wait())
+}
+
+func TestLinkCancel(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	other, otherCtx := New(context.Background())
+	other.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	wg.LinkCancel(other, other.Cancel)
+	wg.Go(func(ctx context.Context) error { return errors.New("failed") })
+	assert.EqualError(t, wg.Wait(), "failed")
+	assert.EqualError(t, context.Cause(otherCtx), "failed")
+}
+
+func TestLinkContext(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	external, cancel := context.WithCancelCause(context.Background())
+	wg.LinkContext(external)
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	cancel(errors.New("external"))
+	assert.EqualError(t, wg.Wait(), "external")
+}
+
+func TestWithoutRecover(t *testing.T) {
+	t.Parallel()
+	if os.Getenv("TEST_WITHOUT_RECOVER") == "1" {
+		wg, _ := New(context.Background(), WithoutRecover())
+		wg.Go(func(ctx context.Context) error { panic("boom") })
+		_ = wg.Wait()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestWithoutRecover$")
+	cmd.Env = append(os.Environ(), "TEST_WITHOUT_RECOVER=1")
+	output, err := cmd.CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(output), "panic: boom")
+}
+
+func TestGoNoCtx(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	wg.GoNoCtx(func() error { return errors.New("failed") })
+	assert.EqualError(t, wg.Wait(), "failed")
+}
+
+func TestResultTree(t *testing.T) {
+	t.Parallel()
+	wg, _ := NewResultTree[int](context.Background())
+	for i := 1; i <= 3; i++ {
+		i := i
+		wg.Go(func(ctx context.Context) (int, error) {
+			time.Sleep(time.Duration(3-i) * time.Millisecond)
+			return i, nil
+		})
+	}
+	wg.Sub(func(ctx context.Context, sg *ResultTree[int]) error {
+		sg.Go(func(ctx context.Context) (int, error) { return 4, nil })
+		return nil
+	})
+	results, err := wg.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4}, results)
+}
+
+func TestSharedConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(2), WithSharedConcurrencyLimit())
+	var running, peak atomic.Int32
+	for i := 0; i < 3; i++ {
+		wg.Sub(func(ctx context.Context, sg *Tree) error {
+			for j := 0; j < 4; j++ {
+				sg.Go(func(ctx context.Context) error {
+					n := running.Add(1)
+					for {
+						p := peak.Load()
+						if n <= p || peak.CompareAndSwap(p, n) {
+							break
+						}
+					}
+					time.Sleep(time.Millisecond)
+					running.Add(-1)
+					return nil
+				})
+			}
+			return nil
+		})
+	}
+	assert.NoError(t, wg.Wait())
+	assert.True(t, peak.Load() <= 2)
+}
+
+func TestNoInherit(t *testing.T) {
+	t.Parallel()
+	var limit int
+	var timeout time.Duration
+	wg, _ := New(context.Background(), WithConcurrencyLimit(2), NoInherit(WithTaskTimeout(time.Second)))
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		limit, timeout = sg.ConcurrencyLimit(), sg.taskTimeout
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, time.Duration(0), timeout)
+
+	wg, _ = New(context.Background(), WithConcurrencyLimit(2), WithNoInherit())
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		limit = sg.ConcurrencyLimit()
+		return nil
+	}, WithName("sub"))
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 0, limit)
+}
+
+func TestDefer(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	var order []string
+	wg.Defer(func(err error) { order = append(order, "first: "+err.Error()) })
+	wg.Defer(func(err error) { order = append(order, "second: "+err.Error()) })
+	wg.Go(func(ctx context.Context) error { return errors.New("failed") })
+	assert.EqualError(t, wg.Wait(), "failed")
+	assert.Equal(t, []string{"second: failed", "first: failed"}, order)
+
+	// Wait blocks until deferred functions started by an earlier WaitTimeout
+	// have returned.
+	wg, _ = New(context.Background())
+	var deferred atomic.Bool
+	wg.Defer(func(err error) {
+		time.Sleep(time.Millisecond * 50)
+		deferred.Store(true)
+	})
+	wg.Go(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond * 10)
+		return nil
+	})
+	assert.IsError(t, wg.WaitTimeout(time.Millisecond), ErrWaitTimeout)
+	time.Sleep(time.Millisecond * 20)
+	assert.NoError(t, wg.Wait())
+	assert.True(t, deferred.Load())
+}
+
+func TestWithSignals(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithSignals(os.Interrupt))
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	process, err := os.FindProcess(os.Getpid())
+	assert.NoError(t, err)
+	assert.NoError(t, process.Signal(os.Interrupt))
+	err = wg.Wait()
+	var signalErr *SignalError
+	assert.True(t, errors.As(err, &signalErr))
+	assert.Equal(t, os.Interrupt, signalErr.Signal)
+}
+
+func TestCancelTag(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	started := make(chan struct{}, 3)
+	release := make(chan struct{})
+	wg.GoTagged("a", func(ctx context.Context) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	wg.SubTagged("a", func(ctx context.Context, sg *Tree) error {
+		sg.Go(func(ctx context.Context) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return context.Cause(ctx)
+		})
+		return nil
+	})
+	var bErr error
+	wg.GoTagged("b", func(ctx context.Context) error {
+		started <- struct{}{}
+		<-release
+		bErr = ctx.Err()
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	wg.CancelTag("a", errors.New("revoked"))
+	close(release)
+	assert.NoError(t, wg.Wait())
+	assert.NoError(t, bErr)
+	assert.Equal(t, Stats{Completed: 3}, wg.Stats())
+}
+
+func TestErrorPaths(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithName("root"), WithErrorPaths())
+	wg.Sub(func(ctx context.Context, sg *Tree) error {
+		sg.GoNamed("worker-3", func(ctx context.Context) error { return errors.New("connection refused") })
+		return nil
+	}, WithName("ingest"))
+	err := wg.Wait()
+	assert.EqualError(t, err, "root/ingest/worker-3: connection refused")
+	var taskErr *TaskError
+	assert.True(t, errors.As(err, &taskErr))
+	assert.Equal(t, []string{"root", "ingest", "worker-3"}, taskErr.Path)
+}
+
+func TestChannelCloseWait(t *testing.T) {
+	t.Parallel()
+	results := make(chan int)
+	wg, _ := ToChannel(context.Background(), results)
+	for i := 0; i < 3; i++ {
+		i := i
+		wg.Go(func(ctx context.Context) (int, error) { return i, nil })
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- wg.CloseWait() }()
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{0, 1, 2}, actual)
+	assert.NoError(t, <-errs)
+	assert.NoError(t, wg.CloseWait())
+}
+
+func TestNewChannel(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 0)
+	wg.Sub(func(ctx context.Context, sg *Channel[int]) error {
+		sg.Go(func(ctx context.Context) (int, error) { return 1, nil })
+		return nil
+	})
+	wg.Go(func(ctx context.Context) (int, error) { return 2, nil })
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{1, 2}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestChannelGoMany(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 3)
+	wg.GoMany(func(ctx context.Context, emit func(int) error) error {
+		for i := 0; i < 3; i++ {
+			if err := emit(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{0, 1, 2}, actual)
+}
+
+func TestMapChannel(t *testing.T) {
+	t.Parallel()
+	in := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	close(in)
+	wg, results := MapChannel(context.Background(), in, 2, func(ctx context.Context, value int) (string, error) {
+		return fmt.Sprint(value * 2), nil
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []string{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Strings(actual)
+	assert.Equal(t, []string{"2", "4", "6"}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestFilterChannel(t *testing.T) {
+	t.Parallel()
+	in := make(chan int, 4)
+	for i := 1; i <= 4; i++ {
+		in <- i
+	}
+	close(in)
+	wg, results := FilterChannel(context.Background(), in, 2, func(ctx context.Context, value int) (bool, error) {
+		return value%2 == 0, nil
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{2, 4}, actual)
+	assert.NoError(t, <-errs)
+
+	in = make(chan int, 1)
+	in <- 1
+	close(in)
+	wg, _ = FilterChannel(context.Background(), in, 1, func(ctx context.Context, value int) (bool, error) {
+		return false, errors.New("failed")
+	})
+	assert.EqualError(t, wg.Wait(), "failed")
+}
+
+func TestBatchChannel(t *testing.T) {
+	t.Parallel()
+	in := make(chan int)
+	wg, batches := BatchChannel(context.Background(), in, 2, time.Millisecond*10)
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	in <- 1
+	in <- 2
+	assert.Equal(t, []int{1, 2}, <-batches)
+	in <- 3
+	assert.Equal(t, []int{3}, <-batches) // Flushed by maxDelay.
+	in <- 4
+	close(in)
+	assert.Equal(t, []int{4}, <-batches)
+	_, ok := <-batches
+	assert.False(t, ok)
+	assert.NoError(t, <-errs)
+}
+
+func TestChannelOrdered(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 0, Ordered(), WithConcurrencyLimit(4))
+	for i := 0; i < 8; i++ {
+		i := i
+		wg.Go(func(ctx context.Context) (int, error) {
+			time.Sleep(time.Duration(8-i) * time.Millisecond)
+			return i, nil
+		})
+	}
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, actual)
+	assert.NoError(t, <-errs)
+
+	in := make(chan int, 8)
+	for i := 0; i < 8; i++ {
+		in <- i
+	}
+	close(in)
+	stage, results := MapChannel(context.Background(), in, 4, func(ctx context.Context, value int) (int, error) {
+		time.Sleep(time.Duration(8-value) * time.Millisecond)
+		return value * 2, nil
+	}, Ordered())
+	go func() { errs <- stage.Wait() }()
+	actual = []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{0, 2, 4, 6, 8, 10, 12, 14}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestChannelConsume(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 0)
+	src := make(chan int, 2)
+	src <- 1
+	src <- 2
+	close(src)
+	wg.Consume(src)
+	wg.Go(func(ctx context.Context) (int, error) { return 3, nil })
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{1, 2, 3}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestTee(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	src := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		src <- i
+	}
+	close(src)
+	all := make(chan int, 3)
+	latest := make(chan int, 1)
+	var dropped []int
+	Tee(wg, src,
+		TeeDest[int]{Dest: all},
+		TeeDest[int]{Dest: latest, Backpressure: DropOldest(), OnDrop: func(value int) { dropped = append(dropped, value) }})
+	assert.NoError(t, wg.Wait())
+	actual := []int{}
+	for value := range all {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{1, 2, 3}, actual)
+	assert.Equal(t, 3, <-latest)
+	assert.Equal(t, []int{1, 2}, dropped)
+
+	wg, _ = New(context.Background())
+	src = make(chan int, 2)
+	src <- 1
+	src <- 2
+	close(src)
+	Tee(wg, src, TeeDest[int]{Dest: make(chan int, 1), Backpressure: ErrorAfter(time.Millisecond)})
+	assert.IsError(t, wg.Wait(), ErrSendTimeout)
+}
+
+func TestFromChannel(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	src := make(chan int, 4)
+	for i := 1; i <= 4; i++ {
+		src <- i
+	}
+	close(src)
+	var sum atomic.Int64
+	FromChannel(wg, src, 2, func(ctx context.Context, value int) error {
+		sum.Add(int64(value))
+		return nil
+	})
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, int64(10), sum.Load())
+}
+
+func TestChannelBackpressure(t *testing.T) {
+	t.Parallel()
+	var dropped []int
+	wg, results, _ := NewChannel[int](context.Background(), 1, WithBackpressure(DropNewest()),
+		OnDrop(func(value int) { dropped = append(dropped, value) }), WithFIFO(), WithConcurrencyLimit(1))
+	for i := 1; i <= 3; i++ {
+		i := i
+		wg.Go(func(ctx context.Context) (int, error) { return i, nil })
+	}
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 1, <-results)
+	assert.Equal(t, []int{2, 3}, dropped)
+
+	wg, _, _ = NewChannel[int](context.Background(), 0, WithBackpressure(ErrorAfter(time.Millisecond)))
+	wg.Go(func(ctx context.Context) (int, error) { return 1, nil })
+	assert.IsError(t, wg.Wait(), ErrSendTimeout)
+}
+
+func TestChannelSubOptions(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 1)
+	wg.Sub(func(ctx context.Context, sg *Channel[int]) error {
+		sg.Go(func(ctx context.Context) (int, error) { return sg.tree.ConcurrencyLimit(), nil })
+		return nil
+	}, WithConcurrencyLimit(3))
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 3, <-results)
+}
+
+func TestChannelDeduplicate(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[string](context.Background(), 4, DeduplicateBy(strings.ToLower))
+	for _, value := range []string{"a", "A", "b", "a"} {
+		value := value
+		wg.Go(func(ctx context.Context) (string, error) { return value, nil })
+	}
+	assert.NoError(t, wg.Wait())
+	actual := []string{}
+	for value := range results {
+		actual = append(actual, strings.ToLower(value))
+	}
+	sort.Strings(actual)
+	assert.Equal(t, []string{"a", "b"}, actual)
+
+	ints, intResults, _ := NewChannel[int](context.Background(), 4, Deduplicate[int]())
+	for _, value := range []int{1, 1, 2} {
+		value := value
+		ints.Go(func(ctx context.Context) (int, error) { return value, nil })
+	}
+	assert.NoError(t, ints.Wait())
+	assert.Equal(t, 2, len(intResults))
+}
+
+func TestChannelOptions(t *testing.T) {
+	t.Parallel()
+	dest := make(chan int, 2)
+	options := []Option{Ordered(), WithConcurrencyLimit(2)}
+	wg, _ := ToChannel(context.Background(), dest, options...)
+	wg.Go(func(ctx context.Context) (int, error) {
+		time.Sleep(time.Millisecond * 10)
+		return 1, nil
+	})
+	wg.Go(func(ctx context.Context) (int, error) { return 2, nil })
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 1, <-dest)
+	assert.Equal(t, 2, <-dest)
+
+	assert.Panics(t, func() { _, _, _ = NewChannel[int](context.Background(), 0, Deduplicate[string]()) })
+}
+
+func TestTumblingWindow(t *testing.T) {
+	t.Parallel()
+	in := make(chan int)
+	wg, windows := TumblingWindow(context.Background(), in, time.Millisecond*20)
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	go func() {
+		for i := 1; i <= 3; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	actual := []int{}
+	for window := range windows {
+		assert.NotEqual(t, 0, len(window))
+		actual = append(actual, window...)
+	}
+	assert.Equal(t, []int{1, 2, 3}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Parallel()
+	in := make(chan int)
+	wg, windows := SlidingWindow(context.Background(), in, time.Hour, time.Millisecond*10)
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	in <- 1
+	assert.Equal(t, []int{1}, <-windows)
+	in <- 2
+	window := <-windows
+	for len(window) < 2 {
+		window = <-windows
+	}
+	assert.Equal(t, []int{1, 2}, window)
+	close(in)
+	for range windows {
+	}
+	assert.NoError(t, <-errs)
+}
+
+func TestMergeSorted(t *testing.T) {
+	t.Parallel()
+	source := func(values ...int) <-chan int {
+		ch := make(chan int, len(values))
+		for _, value := range values {
+			ch <- value
+		}
+		close(ch)
+		return ch
+	}
+	wg, merged := MergeSorted(context.Background(), func(a, b int) bool { return a < b },
+		source(1, 4, 7), source(2, 5, 8), source(), source(3, 6, 9))
+	errs := make(chan error, 1)
+	go func() { errs <- wg.Wait() }()
+	actual := []int{}
+	for value := range merged {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9}, actual)
+	assert.NoError(t, <-errs)
+}
+
+func TestChannelMetrics(t *testing.T) {
+	t.Parallel()
+	metrics := &testMetrics{}
+	wg, _, _ := NewChannel[int](context.Background(), 1, WithMetrics(metrics), WithBackpressure(DropNewest()))
+	for i := 0; i < 3; i++ {
+		wg.Go(func(ctx context.Context) (int, error) { return 1, nil })
+	}
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 1, metrics.sent)
+	assert.Equal(t, 2, metrics.dropped)
+}
+
+func TestToChannels(t *testing.T) {
+	t.Parallel()
+	values := make(chan int, 2)
+	errs := make(chan error, 1)
+	wg, _ := ToChannels(context.Background(), values, errs)
+	wg.Go(func(ctx context.Context) (int, error) { return 1, nil })
+	wg.Go(func(ctx context.Context) (int, error) { return 0, errors.New("failed") })
+	wg.Go(func(ctx context.Context) (int, error) { return 2, nil })
+	assert.NoError(t, wg.Wait())
+	assert.EqualError(t, <-errs, "failed")
+	assert.Equal(t, 2, len(values))
+}
+
+func TestResultChannel(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewResultChannel[int](context.Background(), 3)
+	wg.GoNamed("ok", func(ctx context.Context) (int, error) { return 1, nil })
+	wg.GoNamed("failed", func(ctx context.Context) (int, error) { return 0, errors.New("failed") })
+	wg.GoNamed("panicked", func(ctx context.Context) (int, error) { panic("boom") })
+	assert.NoError(t, wg.Wait())
+	actual := map[string]Result[int]{}
+	for result := range results {
+		actual[result.Task.Name] = result
+	}
+	assert.Equal(t, 3, len(actual))
+	assert.Equal(t, 1, actual["ok"].Value)
+	assert.EqualError(t, actual["failed"].Err, "failed")
+	var panicErr *PanicError
+	assert.True(t, errors.As(actual["panicked"].Err, &panicErr))
+}
+
+func TestChannelGoMaybe(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 4)
+	for i := 0; i < 4; i++ {
+		i := i
+		wg.GoMaybe(func(ctx context.Context) (int, bool, error) { return i, i%2 == 0, nil })
+	}
+	assert.NoError(t, wg.Wait())
+	actual := []int{}
+	for value := range results {
+		actual = append(actual, value)
+	}
+	sort.Ints(actual)
+	assert.Equal(t, []int{0, 2}, actual)
+}
+
+func TestChannelEmitRate(t *testing.T) {
+	t.Parallel()
+	wg, results, _ := NewChannel[int](context.Background(), 4, WithEmitRate(100, 1))
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Go(func(ctx context.Context) (int, error) { return 1, nil })
+	}
+	assert.NoError(t, wg.Wait())
+	assert.True(t, time.Since(start) >= time.Millisecond*25, "%s elapsed", time.Since(start))
+	assert.Equal(t, 4, len(results))
+}
+
+func TestPipe(t *testing.T) {
+	t.Parallel()
+	double := func(ctx context.Context, in <-chan int, out chan<- int) error {
+		for value := range in {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- value * 2:
+			}
+		}
+		return nil
+	}
+	format := func(ctx context.Context, in <-chan int, out chan<- string) error {
+		for value := range in {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case out <- fmt.Sprint(value):
+			}
+		}
+		return nil
+	}
+	wg, _ := New(context.Background())
+	src := make(chan int, 3)
+	for i := 1; i <= 3; i++ {
+		src <- i
+	}
+	close(src)
+	doubled := Pipe(wg, src, 0, double)
+	out := Pipe(wg, doubled, 3, Compose(Stage[int, int](double), format))
+	actual := []string{}
+	for value := range out {
+		actual = append(actual, value)
+	}
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, []string{"4", "8", "12"}, actual)
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+	values, wg := Generate(context.Background(), func(ctx context.Context, yield func(int) error) error {
+		for i := 0; i < 3; i++ {
+			if err := yield(i); err != nil {
+				return err
+			}
+		}
+		return errors.New("done")
+	})
+	actual := []int{}
+	for value := range values {
+		actual = append(actual, value)
+	}
+	assert.Equal(t, []int{0, 1, 2}, actual)
+	assert.EqualError(t, wg.Wait(), "done")
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	values := make(chan int)
+	wg.Go(func(ctx context.Context) error {
+		defer close(values)
+		for i := 0; i < 3; i++ {
+			values <- i
+		}
+		return nil
+	})
+	collected := Collect(wg, values)
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, []int{0, 1, 2}, *collected)
+}
+
+func TestReduceChannel(t *testing.T) {
+	t.Parallel()
+	values, producer := Generate(context.Background(), func(ctx context.Context, yield func(int) error) error {
+		for i := 1; i <= 4; i++ {
+			if err := yield(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sum := ReduceChannel(context.Background(), values, "", func(acc string, value int) string { return acc + fmt.Sprint(value) })
+	actual, err := sum.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, "1234", actual)
+	assert.NoError(t, producer.Wait())
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := Filter(wg, []int{1, 2, 3, 4, 5}, func(ctx context.Context, value int) (bool, error) {
+		return value%2 == 1, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5}, actual)
+}
+
+func TestFlatMap(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := FlatMap(wg, []string{"a b", "", "c"}, func(ctx context.Context, value string) ([]string, error) {
+		return strings.Fields(value), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, actual)
+}
+
+func TestForEach(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	var sum atomic.Int64
+	err := ForEach(wg, []int64{1, 2, 3}, func(ctx context.Context, value int64) error {
+		sum.Add(value)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), sum.Load())
+
+	wg, _ = New(context.Background())
+	err = ForEach(wg, []int{1}, func(ctx context.Context, value int) error { return errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+}
+
+func TestReduce(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := Reduce(wg, []int{1, 2, 3, 4, 5}, func(ctx context.Context, value int) (string, error) {
+		return fmt.Sprint(value), nil
+	}, func(a, b string) string { return a + b })
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", actual)
+
+	wg, _ = New(context.Background())
+	empty, err := Reduce(wg, nil, func(ctx context.Context, value int) (int, error) { return value, nil }, func(a, b int) int { return a + b })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, empty)
+}
+
+func TestMapReduce(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(2))
+	counts, err := MapReduce(wg, []string{"a b", "b c", "c c"},
+		func(ctx context.Context, line string, emit func(string, int)) error {
+			for _, word := range strings.Fields(line) {
+				emit(word, 1)
+			}
+			return nil
+		},
+		func(ctx context.Context, word string, counts []int) (int, error) {
+			return len(counts), nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, counts)
+}
+
+func TestPartition(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	odd, even, err := Partition(wg, []int{1, 2, 3, 4, 5}, func(ctx context.Context, value int) (bool, error) {
+		return value%2 == 1, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3, 5}, odd)
+	assert.Equal(t, []int{2, 4}, even)
+}
+
+func TestGroupBy(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	groups, err := GroupBy(wg, []string{"apple", "avocado", "banana"}, func(ctx context.Context, value string) (byte, error) {
+		return value[0], nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[byte][]string{'a': {"apple", "avocado"}, 'b': {"banana"}}, groups)
+}
+
+func TestMapM(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := MapM(wg, map[string]int{"a": 1, "b": 2}, func(ctx context.Context, key string, value int) (string, error) {
+		return strings.Repeat(key, value), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "a", "b": "bb"}, actual)
+}
+
+func TestMapIndexedError(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithContinueOnError())
+	_, err := Map(wg, []int{1, 2, 3, 4}, func(ctx context.Context, value int) (int, error) {
+		if value%2 == 0 {
+			return 0, fmt.Errorf("%d is even", value)
+		}
+		return value, nil
+	})
+	indexed := IndexedErrors(err)
+	sort.Slice(indexed, func(i, j int) bool { return indexed[i].Index < indexed[j].Index })
+	assert.Equal(t, 2, len(indexed))
+	assert.Equal(t, 1, indexed[0].Index)
+	assert.EqualError(t, indexed[1], "index 3: 4 is even")
+}
+
+func TestMapChunked(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	var chunks atomic.Int32
+	actual, err := MapChunked(wg, []int{1, 2, 3, 4, 5}, 2, func(ctx context.Context, chunk []int) ([]int, error) {
+		chunks.Add(1)
+		out := make([]int, len(chunk))
+		for i, value := range chunk {
+			out[i] = value * 10
+		}
+		return out, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10, 20, 30, 40, 50}, actual)
+	assert.Equal(t, int32(3), chunks.Load())
+
+	wg, _ = New(context.Background())
+	_, err = MapChunked(wg, []int{1}, 0, func(ctx context.Context, chunk []int) ([]int, error) { return chunk, nil })
+	assert.EqualError(t, err, "concurrency: chunk size must be positive but is 0")
+}
+
+func TestMapWithIndex(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := MapWithIndex(wg, []string{"a", "b"}, func(ctx context.Context, index int, value string) (string, error) {
+		return fmt.Sprintf("%d:%s", index, value), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0:a", "1:b"}, actual)
+}
+
+func TestTryMap(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	actual, err := TryMap(wg, []int{1, 2, 3, 4}, func(ctx context.Context, value int) (int, error) {
+		if value%2 == 0 {
+			return 0, errors.New("even")
+		}
+		return value, nil
+	})
+	assert.Equal(t, []int{1, 0, 3, 0}, actual)
+	indexed := IndexedErrors(err)
+	assert.Equal(t, 2, len(indexed))
+	assert.Equal(t, 1, indexed[0].Index)
+	assert.Equal(t, 3, indexed[1].Index)
+}
+
+func TestFirst(t *testing.T) {
+	t.Parallel()
+	actual, err := First(context.Background(),
+		func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+		func(ctx context.Context) (string, error) { return "", errors.New("failed") },
+		func(ctx context.Context) (string, error) { return "fast", nil },
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", actual)
+
+	_, err = First(context.Background(),
+		func(ctx context.Context) (string, error) { return "", errors.New("a") },
+		func(ctx context.Context) (string, error) { return "", errors.New("b") },
+	)
+	assert.Contains(t, err.Error(), "a")
+	assert.Contains(t, err.Error(), "b")
+}
+
+func TestAnyAll(t *testing.T) {
+	t.Parallel()
+	isEven := func(ctx context.Context, value int) (bool, error) { return value%2 == 0, nil }
+	wg, _ := New(context.Background())
+	ok, err := Any(wg, []int{1, 3, 4}, isEven)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	wg, _ = New(context.Background())
+	ok, err = Any(wg, []int{1, 3}, isEven)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	wg, _ = New(context.Background())
+	ok, err = All(wg, []int{2, 4}, isEven)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	wg, _ = New(context.Background())
+	blocked := func(ctx context.Context, value int) (bool, error) {
+		if value == 0 {
+			<-ctx.Done()
+			return false, ctx.Err()
+		}
+		return false, nil
+	}
+	ok, err = All(wg, []int{0, 1}, blocked)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	failing := func(ctx context.Context, value int) (bool, error) { return true, errors.New("boom") }
+	wg, _ = New(context.Background())
+	ok, err = All(wg, []int{1}, failing)
+	assert.EqualError(t, err, "boom")
+	assert.False(t, ok)
+
+	wg, _ = New(context.Background())
+	ok, err = Any(wg, []int{1}, failing)
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+	base := time.Date(2024, time.January, 1, 10, 30, 15, 0, time.UTC) // Monday
+	tests := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/5 * * * * *", time.Date(2024, time.January, 1, 10, 30, 20, 0, time.UTC)},
+		{"0 12 * * *", time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, time.January, 2, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 feb *", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.January, 7, 0, 0, 0, 0, time.UTC)},
+		{"15,45 10 * * *", time.Date(2024, time.January, 1, 10, 45, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCron(test.spec)
+		assert.NoError(t, err, test.spec)
+		assert.Equal(t, test.expected, schedule.Next(base), test.spec)
+	}
+	for _, spec := range []string{"* * *", "60 * * * *", "* * * * foo", "*/0 * * * *", "5-1 * * * *"} {
+		_, err := ParseCron(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduleCron(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	calls := 0
+	scheduled, err := ScheduleCron(wg, "* * * * * *", func(ctx context.Context) error {
+		calls++
+		return errors.New("done")
+	})
+	assert.NoError(t, err)
+	assert.EqualError(t, wg.Wait(), "done")
+	assert.Equal(t, 1, calls)
+	_, err = scheduled.LastRun()
+	assert.EqualError(t, err, "done")
+
+	wg, _ = New(context.Background())
+	calls = 0
+	scheduled, err = ScheduleCron(wg, "@yearly", func(ctx context.Context) error {
+		calls++
+		return nil
+	}, MaxRuns(1))
+	assert.NoError(t, err)
+	scheduled.TriggerNow()
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 1, calls)
+
+	wg, _ = New(context.Background())
+	_, err = ScheduleCron(wg, "bad", func(ctx context.Context) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestScheduleFixedRate(t *testing.T) {
+	t.Parallel()
+	run := func(policy OverlapPolicy) []time.Time {
+		wg, _ := New(context.Background())
+		var starts []time.Time
+		Schedule(wg, func(ctx context.Context) (time.Duration, error) {
+			starts = append(starts, time.Now())
+			if len(starts) == 1 {
+				time.Sleep(time.Millisecond * 50)
+			}
+			if len(starts) == 5 {
+				return 0, errors.New("done")
+			}
+			return time.Millisecond * 10, nil
+		}, FixedRate(policy))
+		assert.EqualError(t, wg.Wait(), "done")
+		return starts
+	}
+
+	starts := run(OverlapSkip)
+	assert.True(t, starts[1].Sub(starts[0]) >= time.Millisecond*50)
+
+	starts = run(OverlapBurst)
+	assert.True(t, starts[4].Sub(starts[1]) < time.Millisecond*25)
+}
+
+func TestScheduled(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	runs := make(chan struct{})
+	scheduled := Schedule(wg, func(ctx context.Context) (time.Duration, error) {
+		runs <- struct{}{}
+		return time.Hour, nil
+	})
+	<-runs
+	scheduled.TriggerNow()
+	<-runs
+	last, err := scheduled.LastRun()
+	scheduled.Stop()
+	assert.NoError(t, wg.Wait())
+	assert.NoError(t, err)
+	assert.False(t, last.IsZero())
+
+	wg, _ = New(context.Background())
+	scheduled = Schedule(wg, func(ctx context.Context) (time.Duration, error) {
+		return 0, errors.New("failed")
+	})
+	assert.EqualError(t, wg.Wait(), "failed")
+	_, err = scheduled.LastRun()
+	assert.EqualError(t, err, "failed")
+}
+
+func TestScheduleOptions(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	start := time.Now()
+	var runs []time.Time
+	Schedule(wg, func(ctx context.Context) (time.Duration, error) {
+		runs = append(runs, time.Now())
+		return time.Millisecond, nil
+	}, InitialDelay(time.Millisecond*20), MaxRuns(3))
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, 3, len(runs))
+	assert.True(t, runs[0].Sub(start) >= time.Millisecond*20)
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+	attempts := 0
+	err := Retry(context.Background(), RetryPolicy{MaxAttempts: 5, Backoff: ConstantBackoff(time.Millisecond)}, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	value, err := RetryValue(context.Background(), RetryPolicy{MaxAttempts: 3}, func(ctx context.Context) (int, error) {
+		attempts++
+		return attempts, errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, 3, value)
+
+	attempts = 0
+	err = Retry(context.Background(), RetryPolicy{MaxElapsed: time.Millisecond * 20, Backoff: ExponentialBackoff(time.Millisecond*5, time.Second)}, func(ctx context.Context) error {
+		attempts++
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.True(t, attempts >= 2 && attempts <= 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	err = Retry(ctx, RetryPolicy{}, func(ctx context.Context) error {
+		attempts++
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	t.Parallel()
+	backoff := DecorrelatedJitterBackoff(time.Millisecond, time.Millisecond*50)
+	var delay time.Duration
+	for attempt := 1; attempt < 20; attempt++ {
+		delay = backoff(attempt, delay)
+		assert.True(t, delay >= time.Millisecond && delay <= time.Millisecond*50)
+	}
+}
+
+func TestRetryClassification(t *testing.T) {
+	t.Parallel()
+	attempts := 0
+	err := Retry(context.Background(), RetryPolicy{}, func(ctx context.Context) error {
+		attempts++
+		return Permanent(errors.New("fatal"))
+	})
+	assert.EqualError(t, err, "fatal")
+	assert.Equal(t, 1, attempts)
+
+	errTransient := errors.New("transient")
+	attempts = 0
+	var retries []int
+	err = Retry(context.Background(), RetryPolicy{
+		IsRetryable: func(err error) bool { return errors.Is(err, errTransient) },
+		OnRetry: func(attempt int, err error, next time.Duration) {
+			assert.Equal(t, errTransient, err)
+			retries = append(retries, attempt)
+		},
+	}, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errTransient
+		}
+		return errors.New("other")
+	})
+	assert.EqualError(t, err, "other")
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, []int{1, 2}, retries)
+}
+
+func TestConflateChannel(t *testing.T) {
+	t.Parallel()
+	type update struct {
+		key   string
+		value int
+	}
+	in := make(chan update)
+	out, values := ConflateChannel(context.Background(), in, func(u update) string { return u.key })
+	// Nothing is consumed until in is closed, so updates are conflated.
+	for i, key := range []string{"a", "b", "a", "c", "b", "a"} {
+		in <- update{key, i}
+	}
+	close(in)
+	errs := make(chan error, 1)
+	go func() { errs <- out.Wait() }()
+	var actual []update
+	for value := range values {
+		actual = append(actual, value)
+	}
+	assert.NoError(t, <-errs)
+	// One value may be in flight, and the last may not be conflated before
+	// consumption starts, but the rest must have been.
+	assert.True(t, len(actual) <= 5)
+	latest := map[string]int{}
+	for _, u := range actual {
+		latest[u.key] = u.value
+	}
+	assert.Equal(t, map[string]int{"a": 5, "b": 4, "c": 3}, latest)
+}
+
+func TestConflateChannelOptions(t *testing.T) {
+	t.Parallel()
+	in := make(chan int)
+	out, values := ConflateChannel(context.Background(), in, func(v int) int { return v }, WithEmitRate(20, 1))
+	errs := make(chan error, 1)
+	go func() { errs <- out.Wait() }()
+	go func() {
+		for i := 0; i < 3; i++ {
+			in <- i
+		}
+		close(in)
+	}()
+	start := time.Now()
+	actual := []int{}
+	for value := range values {
+		actual = append(actual, value)
+	}
+	assert.NoError(t, <-errs)
+	assert.Equal(t, []int{0, 1, 2}, actual)
+	assert.True(t, time.Since(start) >= time.Millisecond*90)
+}
+
+func TestLimit(t *testing.T) {
+	t.Parallel()
+	var running, peak atomic.Int32
+	square := Limit(func(ctx context.Context, value int) (int, error) {
+		current := running.Add(1)
+		for {
+			old := peak.Load()
+			if current <= old || peak.CompareAndSwap(old, current) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 5)
+		running.Add(-1)
+		return value * value, nil
+	}, 2)
+	wg, _ := New(context.Background())
+	results := make([]int, 8)
+	for i := range results {
+		i := i
+		wg.Go(func(ctx context.Context) error {
+			var err error
+			results[i], err = square(ctx, i)
+			return err
+		})
+	}
+	assert.NoError(t, wg.Wait())
+	assert.Equal(t, []int{0, 1, 4, 9, 16, 25, 36, 49}, results)
+	assert.True(t, peak.Load() <= 2)
+
+	release := make(chan struct{})
+	held := make(chan struct{})
+	blocked := Limit(func(ctx context.Context, value int) (int, error) {
+		close(held)
+		<-release
+		return value, nil
+	}, 1)
+	go blocked(context.Background(), 1) //nolint:errcheck
+	<-held
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := blocked(ctx, 2)
+	close(release)
+	assert.IsError(t, err, context.Canceled)
+}
+
+func TestCallValue(t *testing.T) {
+	t.Parallel()
+	future := CallValue(context.Background(), func() (int, error) { return 42, nil })
+	value, err := future.Get(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 42, value)
+
+	future = CallValue(context.Background(), func() (int, error) { return 0, errors.New("failed") })
+	_, err = future.Get(context.Background())
+	assert.EqualError(t, err, "failed")
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	future = CallValue(ctx, func() (int, error) {
+		<-release
+		return 1, nil
+	})
+	cancel(errors.New("parent cancelled"))
+	_, err = future.Get(context.Background())
+	assert.EqualError(t, err, "parent cancelled")
+}
+
+func TestCallPanic(t *testing.T) {
+	t.Parallel()
+	ctx := Call(context.Background(), func() error { panic("boom") })
+	<-ctx.Done()
+	var perr *PanicError
+	assert.True(t, errors.As(context.Cause(ctx), &perr))
+	assert.Equal(t, any("boom"), perr.Value)
+
+	future := CallValue(context.Background(), func() (int, error) { panic("boom") })
+	_, err := future.Get(context.Background())
+	assert.True(t, errors.As(err, &perr))
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	t.Parallel()
+	err := RunWithTimeout(context.Background(), time.Second, func(ctx context.Context) error { return nil })
+	assert.NoError(t, err)
+
+	err = RunWithTimeout(context.Background(), time.Second, func(ctx context.Context) error { return errors.New("failed") })
+	assert.EqualError(t, err, "failed")
+
+	release := make(chan struct{})
+	err = RunWithTimeout(context.Background(), time.Millisecond*10, func(ctx context.Context) error {
+		<-release // Ignores cancellation.
+		return nil
+	})
+	var terr *TimeoutError
+	assert.True(t, errors.As(err, &terr))
+	assert.Equal(t, time.Millisecond*10, terr.Timeout)
+	assert.True(t, terr.Elapsed >= time.Millisecond*10)
+	assert.IsError(t, err, context.DeadlineExceeded)
+	select {
+	case <-terr.Done:
+		t.Fatal("function should still be running")
+	default:
+	}
+	close(release)
+	<-terr.Done
+
+	err = RunWithTimeout(context.Background(), time.Millisecond*10, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	assert.True(t, errors.As(err, &terr))
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("parent cancelled"))
+	err = RunWithTimeout(ctx, time.Second, func(ctx context.Context) error {
+		<-ctx.Done()
+		return context.Cause(ctx)
+	})
+	assert.EqualError(t, err, "parent cancelled")
+
+	release = make(chan struct{})
+	err = RunWithTimeout(ctx, time.Second, func(ctx context.Context) error {
+		<-release // Ignores cancellation.
+		return nil
+	})
+	var cerr *CancelledError
+	assert.True(t, errors.As(err, &cerr))
+	assert.EqualError(t, err, "parent cancelled")
+	close(release)
+	<-cerr.Done
+}
+
+func TestReduceSingleWait(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithTimeout(time.Millisecond*50))
+	_, err := Reduce(wg, []int{1, 2}, func(ctx context.Context, value int) (int, error) { return value, nil },
+		func(a, b int) int {
+			time.Sleep(time.Millisecond * 200)
+			return a + b
+		})
+	var terr *TreeTimeoutError
+	assert.True(t, errors.As(err, &terr))
+
+	wg, _ = New(context.Background(), WithTimeout(time.Millisecond*50))
+	_, err = MapReduce(wg, []int{1, 2},
+		func(ctx context.Context, value int, emit func(int, int)) error {
+			emit(value%2, value)
+			return nil
+		},
+		func(ctx context.Context, key int, values []int) (int, error) {
+			time.Sleep(time.Millisecond * 200)
+			return len(values), nil
+		})
+	assert.True(t, errors.As(err, &terr))
+}
+
+func TestReduceQueueLimit(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background(), WithConcurrencyLimit(1), WithQueueLimit(1))
+	sum, err := Reduce(wg, []int{1, 2, 3, 4}, func(ctx context.Context, value int) (int, error) { return value, nil },
+		func(a, b int) int { return a + b })
+	assert.NoError(t, err)
+	assert.Equal(t, 10, sum)
+
+	wg, _ = New(context.Background(), WithConcurrencyLimit(1), WithQueueLimit(1))
+	counts, err := MapReduce(wg, []int{1, 2, 3, 4},
+		func(ctx context.Context, value int, emit func(int, int)) error {
+			emit(value%2, value)
+			return nil
+		},
+		func(ctx context.Context, key int, values []int) (int, error) {
+			return len(values), nil
+		})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{0: 2, 1: 2}, counts)
+}
+
+func TestSubResultResolves(t *testing.T) {
+	t.Parallel()
+	wg, _ := New(context.Background())
+	assert.NoError(t, wg.Drain(context.Background()))
+	future := SubResult(wg, func(ctx context.Context, sub *Tree) (int, error) { return 1, nil })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := future.Get(ctx)
+	var derr *DiscardedError
+	assert.True(t, errors.As(err, &derr))
+
+	wg, _ = New(context.Background())
+	future = SubResult(wg, func(ctx context.Context, sub *Tree) (int, error) { panic("boom") })
+	_, err = future.Get(ctx)
+	var perr *PanicError
+	assert.True(t, errors.As(err, &perr))
+	assert.Error(t, wg.Wait())
+
+	wg, _ = New(context.Background())
+	future = SubResult(wg, func(ctx context.Context, sub *Tree) (int, error) { return 0, errors.New("failed") })
+	_, err = future.Get(ctx)
+	assert.EqualError(t, err, "failed")
+	assert.EqualError(t, wg.Wait(), "failed")
+
+	wg, _ = New(context.Background())
+	wg.Pause()
+	future = SubResult(wg, func(ctx context.Context, sub *Tree) (int, error) { return 1, nil })
+	wg.Cancel(errors.New("cancelled"))
+	assert.EqualError(t, wg.Wait(), "cancelled")
+	_, err = future.Get(ctx)
+	assert.EqualError(t, err, "cancelled")
+}
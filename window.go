@@ -0,0 +1,98 @@
+package concurrency
+
+import (
+	"context"
+	"time"
+)
+
+// TumblingWindow starts a pipeline stage that groups values received from in
+// into consecutive, non-overlapping windows of duration interval, sending each
+// non-empty window to the returned channel.
+//
+// The final partial window is sent when in is closed. The returned channel is
+// closed when Wait returns.
+func TumblingWindow[T any](ctx context.Context, in <-chan T, interval time.Duration, options ...Option) (*Channel[[]T], <-chan []T) {
+	out, dest, _ := NewChannel[[]T](ctx, 0, options...)
+	out.tree.Go(func(ctx context.Context) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		var window []T
+		flush := func() error {
+			if len(window) == 0 {
+				return nil
+			}
+			err := out.send(ctx, window)
+			window = nil
+			return err
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case <-ticker.C:
+				if err := flush(); err != nil {
+					return err
+				}
+
+			case value, ok := <-in:
+				if !ok {
+					return flush()
+				}
+				window = append(window, value)
+			}
+		}
+	})
+	return out, dest
+}
+
+// SlidingWindow starts a pipeline stage that sends the values received from in
+// during the last size to the returned channel, every slide.
+//
+// Windows overlap if slide is less than size. Empty windows are not sent. The
+// returned channel is closed when Wait returns.
+func SlidingWindow[T any](ctx context.Context, in <-chan T, size, slide time.Duration, options ...Option) (*Channel[[]T], <-chan []T) {
+	type entry struct {
+		received time.Time
+		value    T
+	}
+	out, dest, _ := NewChannel[[]T](ctx, 0, options...)
+	out.tree.Go(func(ctx context.Context) error {
+		ticker := time.NewTicker(slide)
+		defer ticker.Stop()
+		var entries []entry
+		flush := func(now time.Time) error {
+			expired := 0
+			for expired < len(entries) && now.Sub(entries[expired].received) > size {
+				expired++
+			}
+			entries = entries[expired:]
+			if len(entries) == 0 {
+				return nil
+			}
+			window := make([]T, len(entries))
+			for i, entry := range entries {
+				window[i] = entry.value
+			}
+			return out.send(ctx, window)
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+
+			case now := <-ticker.C:
+				if err := flush(now); err != nil {
+					return err
+				}
+
+			case value, ok := <-in:
+				if !ok {
+					return flush(time.Now())
+				}
+				entries = append(entries, entry{received: time.Now(), value: value})
+			}
+		}
+	})
+	return out, dest
+}